@@ -2,12 +2,14 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
+	_ "time/tzdata" // Time zones for analytics, even where the system has no zoneinfo
 
 	"github.com/drmaq/streamnotification/internal/api"
 	"github.com/drmaq/streamnotification/internal/config"
@@ -15,11 +17,23 @@ import (
 	"github.com/drmaq/streamnotification/internal/discord"
 	"github.com/drmaq/streamnotification/internal/frontend"
 	"github.com/drmaq/streamnotification/internal/logger"
+	"github.com/drmaq/streamnotification/internal/notifier"
+	"github.com/drmaq/streamnotification/internal/slack"
+	"github.com/drmaq/streamnotification/internal/telegram"
 	"github.com/drmaq/streamnotification/internal/twitch"
+	"github.com/drmaq/streamnotification/internal/twitch/fakehelix"
 	"github.com/drmaq/streamnotification/internal/twitter"
+	"github.com/drmaq/streamnotification/internal/webhook"
 )
 
 func main() {
+	// Parse command line flags
+	simulate := flag.Bool("simulate", false, "run against a simulated local Twitch API instead of twitch.tv")
+	flag.Parse()
+	if *simulate {
+		os.Setenv("TWITCH_SIMULATE", "true")
+	}
+
 	// Initialize logger
 	logger := logger.NewLogger()
 	logger.Info("Starting Twitch Stream Notification Bot")
@@ -30,6 +44,25 @@ func main() {
 		logger.Fatal("Failed to load configuration: %v", err)
 	}
 
+	// Start the simulated Twitch API
+	simCtx, stopSimulation := context.WithCancel(context.Background())
+	defer stopSimulation()
+
+	if cfg.TwitchSimulate {
+		sim := fakehelix.NewServer()
+		defer sim.Close()
+
+		// Any streamer added through the web interface exists in the simulation
+		sim.AutoCreateUsers = true
+		go sim.RunDemo(simCtx, 2*time.Minute)
+
+		cfg.TwitchAPIBaseURL = sim.APIBaseURL()
+		cfg.TwitchAuthBaseURL = sim.AuthBaseURL()
+		cfg.TwitchClientID = fakehelix.ClientID
+		cfg.TwitchClientSecret = fakehelix.ClientSecret
+		logger.Warn("Simulating the Twitch API at %s, streamers go live and offline at random", sim.URL)
+	}
+
 	// Connect to database
 	database, err := db.NewDatabase(cfg)
 	if err != nil {
@@ -43,7 +76,7 @@ func main() {
 	}
 
 	// Initialize Discord client
-	discordClient := discord.NewClient(logger)
+	discordClient := discord.NewClient(logger, cfg.DiscordBotToken)
 
 	// Initialize Twitter client
 	twitterClient := twitter.NewClient(
@@ -54,14 +87,31 @@ func main() {
 		cfg.TwitterAccessSecret,
 	)
 
+	// Initialize Slack client
+	slackClient := slack.NewClient(logger)
+
+	// Initialize outgoing webhook client
+	webhookClient := webhook.NewClient(logger)
+
+	// Register notification channels
+	notifiers := notifier.NewRegistry()
+	notifiers.Register(discordClient)
+	notifiers.Register(twitterClient)
+	notifiers.Register(slackClient)
+	notifiers.Register(webhookClient)
+
+	// Telegram is registered without a bot token too, so destinations are rejected
+	// with a clear error rather than as an unknown type
+	notifiers.Register(telegram.NewClient(logger, cfg.TelegramBotToken))
+
 	// Initialize Twitch client
-	twitchClient, err := twitch.NewClient(cfg, logger, discordClient, twitterClient)
+	twitchClient, err := twitch.NewClient(cfg, logger, notifiers)
 	if err != nil {
 		logger.Fatal("Failed to initialize Twitch client: %v", err)
 	}
 
 	// Create API router
-	apiRouter := api.NewRouter(cfg, logger, database, twitchClient)
+	apiRouter := api.NewRouter(cfg, logger, database, twitchClient, notifiers)
 
 	// Create frontend router with API base URL
 	apiBaseURL := fmt.Sprintf("http://localhost:%s", cfg.Port)
@@ -74,6 +124,9 @@ func main() {
 	mainRouter.Handle("/api/", apiRouter.Router)
 	mainRouter.Handle("/ws/", apiRouter.Router)
 
+	// Mount Twitch EventSub webhook callback, which answers 404 unless TWITCH_EVENTSUB_TRANSPORT is webhook
+	mainRouter.Handle("/webhooks/twitch/eventsub", twitchClient.EventSubHandler(database))
+
 	// Mount frontend routes (everything else)
 	mainRouter.Handle("/", frontendRouter.Router)
 
@@ -97,6 +150,10 @@ func main() {
 
 	go twitchClient.StartMonitoring(ctx, database)
 
+	// Start notification dispatcher in a goroutine
+	dispatcher := notifier.NewDispatcher(database, notifiers, logger, cfg.NotificationMaxAttempts)
+	go dispatcher.Run(ctx)
+
 	// Wait for interrupt signal to gracefully shutdown the server
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
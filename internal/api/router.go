@@ -1,14 +1,23 @@
 package api
 
 import (
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/drmaq/streamnotification/internal/config"
 	"github.com/drmaq/streamnotification/internal/db"
+	"github.com/drmaq/streamnotification/internal/errors"
 	"github.com/drmaq/streamnotification/internal/logger"
 	"github.com/drmaq/streamnotification/internal/models"
+	"github.com/drmaq/streamnotification/internal/notifier"
 	"github.com/drmaq/streamnotification/internal/twitch"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
@@ -20,17 +29,19 @@ type Router struct {
 	Logger       *logger.Logger
 	DB           *db.Database
 	TwitchClient *twitch.Client
+	Notifiers    *notifier.Registry
 	Router       *mux.Router
 	upgrader     websocket.Upgrader
 }
 
 // NewRouter creates a new API router
-func NewRouter(cfg *config.Config, logger *logger.Logger, database *db.Database, twitchClient *twitch.Client) *Router {
+func NewRouter(cfg *config.Config, logger *logger.Logger, database *db.Database, twitchClient *twitch.Client, notifiers *notifier.Registry) *Router {
 	r := &Router{
 		Config:       cfg,
 		Logger:       logger,
 		DB:           database,
 		TwitchClient: twitchClient,
+		Notifiers:    notifiers,
 		Router:       mux.NewRouter(),
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
@@ -50,11 +61,29 @@ func (r *Router) setupRoutes() {
 	// API routes
 	r.Router.HandleFunc("/api/streamers", r.handleGetStreamers).Methods("GET")
 	r.Router.HandleFunc("/api/streamers", r.handleAddStreamer).Methods("POST")
+	r.Router.HandleFunc("/api/streamers/{id:[0-9]+}", r.handleUpdateStreamer).Methods("PUT")
 	r.Router.HandleFunc("/api/streamers/{id:[0-9]+}", r.handleDeleteStreamer).Methods("DELETE")
+	r.Router.HandleFunc("/api/streamers/{id:[0-9]+}/sessions", r.handleGetStreamSessions).Methods("GET")
+	r.Router.HandleFunc("/api/streamers/{id:[0-9]+}/sessions/{sid:[0-9]+}/timeline", r.handleGetSessionTimeline).Methods("GET")
+	r.Router.HandleFunc("/api/streamers/{id:[0-9]+}/stats", r.handleGetStreamerStats).Methods("GET")
+	r.Router.HandleFunc("/api/stats", r.handleGetRosterStats).Methods("GET")
+	r.Router.HandleFunc("/api/streamers/{id:[0-9]+}/notifications", r.handleGetStreamerNotifications).Methods("GET")
+	r.Router.HandleFunc("/api/streamers/{id:[0-9]+}/notifications", r.handleSetStreamerNotifications).Methods("PUT")
+	r.Router.HandleFunc("/api/streamers/{id:[0-9]+}/notifications/{nid:[0-9]+}", r.handleAddStreamerNotification).Methods("POST")
+	r.Router.HandleFunc("/api/streamers/{id:[0-9]+}/notifications/{nid:[0-9]+}", r.handleRemoveStreamerNotification).Methods("DELETE")
 	r.Router.HandleFunc("/api/notifications", r.handleGetNotifications).Methods("GET")
 	r.Router.HandleFunc("/api/notifications", r.handleAddNotification).Methods("POST")
+	r.Router.HandleFunc("/api/notifications/types", r.handleGetNotificationTypes).Methods("GET")
+	r.Router.HandleFunc("/api/notifications/preview", r.handlePreviewNotification).Methods("POST")
 	r.Router.HandleFunc("/api/notifications/{id:[0-9]+}", r.handleUpdateNotification).Methods("PUT")
 	r.Router.HandleFunc("/api/notifications/{id:[0-9]+}", r.handleDeleteNotification).Methods("DELETE")
+	r.Router.HandleFunc("/api/notifications/{id:[0-9]+}/filters", r.handleGetNotificationFilters).Methods("GET")
+	r.Router.HandleFunc("/api/notifications/{id:[0-9]+}/filters", r.handleSetNotificationFilters).Methods("PUT")
+	r.Router.HandleFunc("/api/deliveries", r.handleGetDeliveries).Methods("GET")
+	r.Router.HandleFunc("/api/dead-letters", r.handleGetDeadLetters).Methods("GET")
+	r.Router.HandleFunc("/api/dead-letters/{id:[0-9]+}/replay", r.handleReplayDeadLetter).Methods("POST")
+	r.Router.HandleFunc("/api/twitch/status", r.handleGetTwitchStatus).Methods("GET")
+	r.Router.HandleFunc("/api/health", r.handleHealth).Methods("GET")
 	r.Router.HandleFunc("/api/logs", r.handleGetLogs).Methods("GET")
 
 	// WebSocket route for live logs
@@ -113,6 +142,76 @@ func (r *Router) handleAddStreamer(w http.ResponseWriter, req *http.Request) {
 	json.NewEncoder(w).Encode(streamer)
 }
 
+// handleUpdateStreamer handles PUT /api/streamers/{id}
+func (r *Router) handleUpdateStreamer(w http.ResponseWriter, req *http.Request) {
+	// Get streamer ID from URL
+	vars := mux.Vars(req)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		r.Logger.Error("Invalid streamer ID: %v", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	// Parse request, leaving omitted settings unchanged
+	var request struct {
+		NotificationCooldownSeconds *int     `json:"notification_cooldown_seconds"`
+		OfflineGraceSeconds         *int     `json:"offline_grace_seconds"`
+		MilestoneThresholds         *[]int64 `json:"milestone_thresholds"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		r.Logger.Error("Failed to parse request: %v", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	streamer, err := r.DB.GetStreamer(id)
+	if err != nil {
+		errors.HandleHTTPError(w, err, r.Logger)
+		return
+	}
+
+	// Validate settings
+	if v := request.NotificationCooldownSeconds; v != nil {
+		if *v < 0 || *v > 86400 {
+			http.Error(w, "notification_cooldown_seconds must be between 0 and 86400", http.StatusBadRequest)
+			return
+		}
+		streamer.NotificationCooldownSeconds = *v
+	}
+	if v := request.OfflineGraceSeconds; v != nil {
+		if *v < 0 || *v > 3600 {
+			http.Error(w, "offline_grace_seconds must be between 0 and 3600", http.StatusBadRequest)
+			return
+		}
+		streamer.OfflineGraceSeconds = *v
+	}
+	if v := request.MilestoneThresholds; v != nil {
+		thresholds := append([]int64{}, *v...)
+		for _, threshold := range thresholds {
+			if threshold <= 0 {
+				http.Error(w, "milestone_thresholds must be positive", http.StatusBadRequest)
+				return
+			}
+		}
+		sort.Slice(thresholds, func(i, j int) bool { return thresholds[i] < thresholds[j] })
+		streamer.MilestoneThresholds = thresholds
+	}
+
+	// Update streamer in database
+	if err := r.DB.UpdateStreamerSettings(streamer); err != nil {
+		errors.HandleHTTPError(w, err, r.Logger)
+		return
+	}
+
+	// Log success
+	r.Logger.Info("Updated settings for streamer %s", streamer.Username)
+
+	// Return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(streamer)
+}
+
 // handleDeleteStreamer handles DELETE /api/streamers/{id}
 func (r *Router) handleDeleteStreamer(w http.ResponseWriter, req *http.Request) {
 	// Get streamer ID from URL
@@ -138,6 +237,380 @@ func (r *Router) handleDeleteStreamer(w http.ResponseWriter, req *http.Request)
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// handleGetStreamSessions handles GET /api/streamers/{id}/sessions
+func (r *Router) handleGetStreamSessions(w http.ResponseWriter, req *http.Request) {
+	// Get streamer ID from URL
+	vars := mux.Vars(req)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		r.Logger.Error("Invalid streamer ID: %v", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	// Parse paging and date filters
+	var filter db.SessionFilter
+	filter.Limit, filter.Offset, err = parsePaging(req)
+	if err != nil {
+		r.Logger.Error("Invalid paging parameters: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.From, err = parseTimeParam(req, "from", false); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.To, err = parseTimeParam(req, "to", true); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Make sure the streamer exists
+	if _, err := r.DB.GetStreamer(id); err != nil {
+		errors.HandleHTTPError(w, err, r.Logger)
+		return
+	}
+
+	// Get sessions
+	sessions, err := r.DB.GetStreamSessions(id, filter)
+	if err != nil {
+		r.Logger.Error("Failed to get stream sessions: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// handleGetSessionTimeline handles GET /api/streamers/{id}/sessions/{sid}/timeline.
+// The series is returned as JSON, or as CSV with ?format=csv or an Accept: text/csv header.
+func (r *Router) handleGetSessionTimeline(w http.ResponseWriter, req *http.Request) {
+	// Get streamer and session IDs from URL
+	vars := mux.Vars(req)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		r.Logger.Error("Invalid streamer ID: %v", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	sessionID, err := strconv.Atoi(vars["sid"])
+	if err != nil {
+		r.Logger.Error("Invalid session ID: %v", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	// Pick the response format
+	format := req.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+		if strings.Contains(req.Header.Get("Accept"), "text/csv") {
+			format = "csv"
+		}
+	}
+	if format != "json" && format != "csv" {
+		http.Error(w, "format must be json or csv", http.StatusBadRequest)
+		return
+	}
+
+	// Get the session and its samples
+	session, err := r.DB.GetStreamSession(id, sessionID)
+	if err != nil {
+		errors.HandleHTTPError(w, err, r.Logger)
+		return
+	}
+
+	samples, err := r.DB.GetStreamSamples(session.ID)
+	if err != nil {
+		errors.HandleHTTPError(w, err, r.Logger)
+		return
+	}
+
+	// Return CSV response
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"session-%d-timeline.csv\"", session.ID))
+
+		out := csv.NewWriter(w)
+		out.Write([]string{"sampled_at", "viewer_count", "game_name", "title", "bucket_seconds"})
+		for _, sample := range samples {
+			out.Write([]string{
+				sample.SampledAt.Format(time.RFC3339),
+				strconv.Itoa(sample.ViewerCount),
+				sample.GameName,
+				sample.Title,
+				strconv.Itoa(sample.BucketSeconds),
+			})
+		}
+		out.Flush()
+		return
+	}
+
+	// Return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"session": session,
+		"samples": samples,
+	})
+}
+
+// statsPeriod is the default period covered by stream stats
+const statsPeriod = 90 * 24 * time.Hour
+
+// parseStatsParams reads the from, to and tz query parameters of the stats endpoints.
+// The period defaults to the last 90 days and go-live times to UTC.
+func parseStatsParams(req *http.Request) (time.Time, time.Time, *time.Location, error) {
+	to := time.Now()
+	if t, err := parseTimeParam(req, "to", true); err != nil {
+		return time.Time{}, time.Time{}, nil, err
+	} else if t != nil {
+		to = *t
+	}
+
+	from := to.Add(-statsPeriod)
+	if t, err := parseTimeParam(req, "from", false); err != nil {
+		return time.Time{}, time.Time{}, nil, err
+	} else if t != nil {
+		from = *t
+	}
+
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, nil, fmt.Errorf("from must be before to")
+	}
+
+	loc := time.UTC
+	if v := req.URL.Query().Get("tz"); v != "" {
+		var err error
+		if loc, err = time.LoadLocation(v); err != nil {
+			return time.Time{}, time.Time{}, nil, fmt.Errorf("tz must be an IANA time zone, e.g. Europe/Berlin")
+		}
+	}
+
+	return from, to, loc, nil
+}
+
+// handleGetStreamerStats handles GET /api/streamers/{id}/stats
+func (r *Router) handleGetStreamerStats(w http.ResponseWriter, req *http.Request) {
+	// Get streamer ID from URL
+	vars := mux.Vars(req)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		r.Logger.Error("Invalid streamer ID: %v", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	// Parse period and time zone
+	from, to, loc, err := parseStatsParams(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	streamer, err := r.DB.GetStreamer(id)
+	if err != nil {
+		errors.HandleHTTPError(w, err, r.Logger)
+		return
+	}
+
+	// Get sessions and the games played in them
+	sessions, err := r.DB.GetSessionsBetween(id, from, to)
+	if err != nil {
+		errors.HandleHTTPError(w, err, r.Logger)
+		return
+	}
+
+	categoryTime, err := r.DB.GetSessionCategoryTime(sessions)
+	if err != nil {
+		errors.HandleHTTPError(w, err, r.Logger)
+		return
+	}
+
+	stats := models.NewStreamerStats(sessions, categoryTime, from, to, loc)
+	stats.StreamerID = streamer.ID
+	stats.DisplayName = streamer.DisplayName
+
+	// Return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// handleGetRosterStats handles GET /api/stats, covering every streamer with a per-streamer breakdown
+func (r *Router) handleGetRosterStats(w http.ResponseWriter, req *http.Request) {
+	// Parse period and time zone
+	from, to, loc, err := parseStatsParams(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	streamers, err := r.DB.GetStreamers()
+	if err != nil {
+		errors.HandleHTTPError(w, err, r.Logger)
+		return
+	}
+
+	// Get sessions and the games played in them
+	sessions, err := r.DB.GetSessionsBetween(0, from, to)
+	if err != nil {
+		errors.HandleHTTPError(w, err, r.Logger)
+		return
+	}
+
+	categoryTime, err := r.DB.GetSessionCategoryTime(sessions)
+	if err != nil {
+		errors.HandleHTTPError(w, err, r.Logger)
+		return
+	}
+
+	// Break the sessions down by streamer
+	byStreamer := make(map[int][]models.StreamSession)
+	for _, session := range sessions {
+		byStreamer[session.StreamerID] = append(byStreamer[session.StreamerID], session)
+	}
+
+	stats := models.NewStreamerStats(sessions, categoryTime, from, to, loc)
+	stats.Streamers = []models.StreamerStats{}
+	for _, streamer := range streamers {
+		streamerStats := models.NewStreamerStats(byStreamer[streamer.ID], categoryTime, from, to, loc)
+		streamerStats.StreamerID = streamer.ID
+		streamerStats.DisplayName = streamer.DisplayName
+		stats.Streamers = append(stats.Streamers, *streamerStats)
+	}
+
+	// Return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// handleGetStreamerNotifications handles GET /api/streamers/{id}/notifications
+func (r *Router) handleGetStreamerNotifications(w http.ResponseWriter, req *http.Request) {
+	// Get streamer ID from URL
+	vars := mux.Vars(req)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		r.Logger.Error("Invalid streamer ID: %v", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	// Make sure the streamer exists
+	if _, err := r.DB.GetStreamer(id); err != nil {
+		errors.HandleHTTPError(w, err, r.Logger)
+		return
+	}
+
+	// Get the notification settings routed to this streamer
+	notifications, err := r.DB.GetNotificationSettingsForStreamer(id)
+	if err != nil {
+		r.Logger.Error("Failed to get streamer notifications: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	redactSecrets(notifications)
+
+	// Return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notifications)
+}
+
+// handleSetStreamerNotifications handles PUT /api/streamers/{id}/notifications
+func (r *Router) handleSetStreamerNotifications(w http.ResponseWriter, req *http.Request) {
+	// Get streamer ID from URL
+	vars := mux.Vars(req)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		r.Logger.Error("Invalid streamer ID: %v", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	// Parse request
+	var request struct {
+		NotificationIDs []int `json:"notification_ids"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		r.Logger.Error("Failed to parse request: %v", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	// Replace subscriptions
+	if err := r.DB.SetStreamerNotifications(id, request.NotificationIDs); err != nil {
+		errors.HandleHTTPError(w, err, r.Logger)
+		return
+	}
+
+	// Log success
+	r.Logger.Info("Updated notification routing for streamer %d", id)
+
+	// Return the resulting routing
+	r.handleGetStreamerNotifications(w, req)
+}
+
+// handleAddStreamerNotification handles POST /api/streamers/{id}/notifications/{nid}
+func (r *Router) handleAddStreamerNotification(w http.ResponseWriter, req *http.Request) {
+	// Get IDs from URL
+	id, nid, err := parseStreamerNotificationIDs(req)
+	if err != nil {
+		r.Logger.Error("Invalid streamer notification ID: %v", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	// Subscribe streamer to notification
+	if err := r.DB.AddStreamerNotification(id, nid); err != nil {
+		errors.HandleHTTPError(w, err, r.Logger)
+		return
+	}
+
+	// Log success
+	r.Logger.Info("Routed notification %d to streamer %d", nid, id)
+
+	// Return success
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRemoveStreamerNotification handles DELETE /api/streamers/{id}/notifications/{nid}
+func (r *Router) handleRemoveStreamerNotification(w http.ResponseWriter, req *http.Request) {
+	// Get IDs from URL
+	id, nid, err := parseStreamerNotificationIDs(req)
+	if err != nil {
+		r.Logger.Error("Invalid streamer notification ID: %v", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	// Unsubscribe streamer from notification
+	if err := r.DB.RemoveStreamerNotification(id, nid); err != nil {
+		errors.HandleHTTPError(w, err, r.Logger)
+		return
+	}
+
+	// Log success
+	r.Logger.Info("Removed notification %d from streamer %d", nid, id)
+
+	// Return success
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseStreamerNotificationIDs reads the streamer and notification IDs from the URL
+func parseStreamerNotificationIDs(req *http.Request) (int, int, error) {
+	vars := mux.Vars(req)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return 0, 0, err
+	}
+	nid, err := strconv.Atoi(vars["nid"])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return id, nid, nil
+}
+
 // handleGetNotifications handles GET /api/notifications
 func (r *Router) handleGetNotifications(w http.ResponseWriter, req *http.Request) {
 	// Get notification settings
@@ -148,6 +621,8 @@ func (r *Router) handleGetNotifications(w http.ResponseWriter, req *http.Request
 		return
 	}
 
+	redactSecrets(notifications)
+
 	// Return JSON response
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(notifications)
@@ -155,14 +630,27 @@ func (r *Router) handleGetNotifications(w http.ResponseWriter, req *http.Request
 
 // handleAddNotification handles POST /api/notifications
 func (r *Router) handleAddNotification(w http.ResponseWriter, req *http.Request) {
-	// Parse request
-	var notification models.NotificationSetting
+	// Parse request, keeping the all streamers default for clients that omit it
+	notification := models.NotificationSetting{AllStreamers: true}
 	if err := json.NewDecoder(req.Body).Decode(&notification); err != nil {
 		r.Logger.Error("Failed to parse request: %v", err)
 		http.Error(w, "Bad Request", http.StatusBadRequest)
 		return
 	}
 
+	// Generate a signing secret for webhooks created without one
+	if _, err := ensureWebhookSecret(&notification); err != nil {
+		errors.HandleHTTPError(w, err, r.Logger)
+		return
+	}
+
+	// Validate notification
+	if err := r.validateNotification(&notification); err != nil {
+		r.Logger.Error("Invalid notification: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Add notification to database
 	if err := r.DB.AddNotificationSetting(&notification); err != nil {
 		r.Logger.Error("Failed to add notification: %v", err)
@@ -190,8 +678,8 @@ func (r *Router) handleUpdateNotification(w http.ResponseWriter, req *http.Reque
 		return
 	}
 
-	// Parse request
-	var notification models.NotificationSetting
+	// Parse request, keeping the all streamers default for clients that omit it
+	notification := models.NotificationSetting{AllStreamers: true}
 	if err := json.NewDecoder(req.Body).Decode(&notification); err != nil {
 		r.Logger.Error("Failed to parse request: %v", err)
 		http.Error(w, "Bad Request", http.StatusBadRequest)
@@ -201,6 +689,33 @@ func (r *Router) handleUpdateNotification(w http.ResponseWriter, req *http.Reque
 	// Set ID from URL
 	notification.ID = id
 
+	// Keep the stored webhook secret unless a new one is sent, and the stored
+	// header values that are sent back masked
+	existing, err := r.DB.GetNotificationSetting(id)
+	if err != nil {
+		errors.HandleHTTPError(w, err, r.Logger)
+		return
+	}
+	if notification.Secret == "" {
+		notification.Secret = existing.Secret
+	}
+	if err := restoreHeaders(&notification, existing); err != nil {
+		errors.HandleHTTPError(w, err, r.Logger)
+		return
+	}
+	generated, err := ensureWebhookSecret(&notification)
+	if err != nil {
+		errors.HandleHTTPError(w, err, r.Logger)
+		return
+	}
+
+	// Validate notification
+	if err := r.validateNotification(&notification); err != nil {
+		r.Logger.Error("Invalid notification: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Update notification in database
 	if err := r.DB.UpdateNotificationSetting(&notification); err != nil {
 		r.Logger.Error("Failed to update notification: %v", err)
@@ -211,6 +726,12 @@ func (r *Router) handleUpdateNotification(w http.ResponseWriter, req *http.Reque
 	// Log success
 	r.Logger.Info("Updated notification setting: %s to %s", notification.Type, notification.Destination)
 
+	// Only return a secret that was just generated
+	if !generated {
+		notification.Secret = ""
+	}
+	maskHeaders(&notification)
+
 	// Return JSON response
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(notification)
@@ -241,6 +762,420 @@ func (r *Router) handleDeleteNotification(w http.ResponseWriter, req *http.Reque
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// handleGetNotificationFilters handles GET /api/notifications/{id}/filters
+func (r *Router) handleGetNotificationFilters(w http.ResponseWriter, req *http.Request) {
+	// Get notification ID from URL
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		r.Logger.Error("Invalid notification ID: %v", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	// Get notification from database
+	notification, err := r.DB.GetNotificationSetting(id)
+	if err != nil {
+		errors.HandleHTTPError(w, err, r.Logger)
+		return
+	}
+
+	// Return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notification.Filters)
+}
+
+// handleSetNotificationFilters handles PUT /api/notifications/{id}/filters
+func (r *Router) handleSetNotificationFilters(w http.ResponseWriter, req *http.Request) {
+	// Get notification ID from URL
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		r.Logger.Error("Invalid notification ID: %v", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	// Parse request
+	var filters models.NotificationFilters
+	if err := json.NewDecoder(req.Body).Decode(&filters); err != nil {
+		r.Logger.Error("Failed to parse request: %v", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	// Get notification from database
+	notification, err := r.DB.GetNotificationSetting(id)
+	if err != nil {
+		errors.HandleHTTPError(w, err, r.Logger)
+		return
+	}
+
+	// Validate and save the filters
+	notification.Filters = filters
+	if err := r.validateNotification(notification); err != nil {
+		r.Logger.Error("Invalid notification filters: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := r.DB.UpdateNotificationSetting(notification); err != nil {
+		errors.HandleHTTPError(w, err, r.Logger)
+		return
+	}
+
+	// Log success
+	r.Logger.Info("Updated filters of notification setting with ID: %d", id)
+
+	// Return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notification.Filters)
+}
+
+// validateNotification checks a notification setting received from a client
+func (r *Router) validateNotification(notification *models.NotificationSetting) error {
+	for _, eventType := range notification.EventTypes {
+		if !models.IsValidEventType(eventType) {
+			return fmt.Errorf("unknown event type: %s", eventType)
+		}
+	}
+
+	return r.Notifiers.Validate(notification)
+}
+
+// ensureWebhookSecret generates a random signing secret for a webhook destination without one,
+// reporting whether it did
+func ensureWebhookSecret(notification *models.NotificationSetting) (bool, error) {
+	if notification.Type != models.NotificationTypeWebhook || notification.Secret != "" {
+		return false, nil
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return false, errors.NewInternalError("Failed to generate webhook secret", err)
+	}
+	notification.Secret = hex.EncodeToString(key)
+	return true, nil
+}
+
+// redactSecrets clears webhook secrets, which are only shown when they are created,
+// and masks custom header values, which often hold credentials
+func redactSecrets(notifications []models.NotificationSetting) {
+	for i := range notifications {
+		notifications[i].Secret = ""
+		maskHeaders(&notifications[i])
+	}
+}
+
+// maskedHeaderValue stands in for custom header values in responses
+const maskedHeaderValue = "********"
+
+// maskHeaders replaces the custom header values of a destination with maskedHeaderValue
+func maskHeaders(notification *models.NotificationSetting) {
+	if len(notification.Headers) == 0 {
+		return
+	}
+
+	masked := make(map[string]string, len(notification.Headers))
+	for name := range notification.Headers {
+		masked[name] = maskedHeaderValue
+	}
+	notification.Headers = masked
+}
+
+// restoreHeaders puts back the stored value of every header sent back masked
+func restoreHeaders(notification, existing *models.NotificationSetting) error {
+	for name, value := range notification.Headers {
+		if value != maskedHeaderValue {
+			continue
+		}
+
+		stored, ok := existing.Headers[name]
+		if !ok {
+			return errors.NewValidationError(fmt.Sprintf("Header %s has no stored value to keep", name), nil)
+		}
+		notification.Headers[name] = stored
+	}
+
+	return nil
+}
+
+// handleGetNotificationTypes handles GET /api/notifications/types
+func (r *Router) handleGetNotificationTypes(w http.ResponseWriter, req *http.Request) {
+	// Return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(r.Notifiers.Capabilities())
+}
+
+// handleGetTwitchStatus handles GET /api/twitch/status
+func (r *Router) handleGetTwitchStatus(w http.ResponseWriter, req *http.Request) {
+	// Return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(r.TwitchClient.Status())
+}
+
+// handleHealth handles GET /api/health. It reports 503 while Twitch rejects the client credentials.
+func (r *Router) handleHealth(w http.ResponseWriter, req *http.Request) {
+	auth := r.TwitchClient.AuthStatus()
+
+	status := "ok"
+	code := http.StatusOK
+	if !auth.CredentialsValid {
+		status = "unhealthy"
+		code = http.StatusServiceUnavailable
+	}
+
+	// Return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":      status,
+		"twitch_auth": auth,
+	})
+}
+
+// handlePreviewNotification handles POST /api/notifications/preview
+func (r *Router) handlePreviewNotification(w http.ResponseWriter, req *http.Request) {
+	// Parse request
+	var request struct {
+		Type       models.NotificationType `json:"type"`
+		EventType  string                  `json:"event_type"`
+		Template   string                  `json:"template"`
+		StreamerID int                     `json:"streamer_id"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		r.Logger.Error("Failed to parse request: %v", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	if request.EventType == "" {
+		request.EventType = models.EventTypeLive
+	}
+	if !models.IsValidEventType(request.EventType) {
+		http.Error(w, fmt.Sprintf("unknown event type: %s", request.EventType), http.StatusBadRequest)
+		return
+	}
+
+	// Render against the streamer's latest stream, or a sample event
+	event := notifier.SampleEvent(request.EventType)
+	if request.StreamerID != 0 {
+		streamer, err := r.DB.GetStreamer(request.StreamerID)
+		if err != nil {
+			errors.HandleHTTPError(w, err, r.Logger)
+			return
+		}
+		event = streamerEvent(streamer, request.EventType)
+	}
+
+	message, err := r.Notifiers.Preview(request.Type, request.Template, event)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": message,
+		"event":   event,
+	})
+}
+
+// streamerEvent builds an event from the latest stream recorded for a streamer
+func streamerEvent(streamer *models.Streamer, eventType string) *models.StreamEvent {
+	event := &models.StreamEvent{
+		StreamerID:  streamer.ID,
+		Username:    streamer.Username,
+		DisplayName: streamer.DisplayName,
+		EventType:   eventType,
+		StreamTitle: streamer.LastStreamTitle,
+		GameName:    streamer.LastGameName,
+		ViewerCount: streamer.PeakViewers,
+		PeakViewers: streamer.PeakViewers,
+		EndedAt:     streamer.LastStreamEnd,
+	}
+	if streamer.LastStreamStart != nil {
+		event.StartedAt = *streamer.LastStreamStart
+	}
+	if eventType != models.EventTypeOffline || (event.EndedAt != nil && event.EndedAt.Before(event.StartedAt)) {
+		event.EndedAt = nil
+	}
+
+	// Changes are previewed against example previous values
+	sample := notifier.SampleEvent(eventType)
+	event.PreviousTitle = sample.PreviousTitle
+	event.PreviousGame = sample.PreviousGame
+
+	return event
+}
+
+// handleGetDeliveries handles GET /api/deliveries
+func (r *Router) handleGetDeliveries(w http.ResponseWriter, req *http.Request) {
+	// Parse filters
+	filter, err := parseDeliveryFilter(req)
+	if err != nil {
+		r.Logger.Error("Invalid delivery filter: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Get deliveries
+	deliveries, err := r.DB.GetDeliveries(filter)
+	if err != nil {
+		r.Logger.Error("Failed to get deliveries: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// parseDeliveryFilter reads the delivery history filters from the query string
+func parseDeliveryFilter(req *http.Request) (db.DeliveryFilter, error) {
+	var filter db.DeliveryFilter
+	var err error
+	query := req.URL.Query()
+
+	if filter.Limit, filter.Offset, err = parsePaging(req); err != nil {
+		return filter, err
+	}
+
+	ids := map[string]*int{
+		"streamer_id":     &filter.StreamerID,
+		"notification_id": &filter.NotificationSettingID,
+		"outbox_id":       &filter.OutboxID,
+	}
+	for name, target := range ids {
+		v := query.Get(name)
+		if v == "" {
+			continue
+		}
+		if *target, err = strconv.Atoi(v); err != nil {
+			return filter, fmt.Errorf("%s must be an integer", name)
+		}
+	}
+
+	switch status := models.DeliveryStatus(query.Get("status")); status {
+	case "", models.DeliveryStatusSent, models.DeliveryStatusFailed, models.DeliveryStatusDead:
+		filter.Status = status
+	default:
+		return filter, fmt.Errorf("unknown status: %s", status)
+	}
+
+	if eventType := query.Get("event_type"); eventType != "" {
+		if !models.IsValidEventType(eventType) {
+			return filter, fmt.Errorf("unknown event type: %s", eventType)
+		}
+		filter.EventType = eventType
+	}
+
+	if filter.From, err = parseTimeParam(req, "from", false); err != nil {
+		return filter, err
+	}
+	if filter.To, err = parseTimeParam(req, "to", true); err != nil {
+		return filter, err
+	}
+
+	return filter, nil
+}
+
+// handleGetDeadLetters handles GET /api/dead-letters
+func (r *Router) handleGetDeadLetters(w http.ResponseWriter, req *http.Request) {
+	// Parse paging parameters
+	limit, offset, err := parsePaging(req)
+	if err != nil {
+		r.Logger.Error("Invalid paging parameters: %v", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	// Get dead letters
+	entries, err := r.DB.GetDeadLetters(limit, offset)
+	if err != nil {
+		r.Logger.Error("Failed to get dead letters: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// handleReplayDeadLetter handles POST /api/dead-letters/{id}/replay
+func (r *Router) handleReplayDeadLetter(w http.ResponseWriter, req *http.Request) {
+	// Get outbox entry ID from URL
+	vars := mux.Vars(req)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		r.Logger.Error("Invalid dead letter ID: %v", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	// Requeue the delivery
+	entry, err := r.DB.ReplayDeadLetter(id)
+	if err != nil {
+		errors.HandleHTTPError(w, err, r.Logger)
+		return
+	}
+
+	// Log success
+	r.Logger.Info("Replaying dead-lettered notification %d", id)
+
+	// Return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entry)
+}
+
+// parsePaging reads the limit and offset query parameters
+func parsePaging(req *http.Request) (int, int, error) {
+	limit := 50
+	offset := 0
+
+	if v := req.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 500 {
+			return 0, 0, fmt.Errorf("limit must be between 1 and 500")
+		}
+		limit = n
+	}
+
+	if v := req.URL.Query().Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("offset must be a non-negative integer")
+		}
+		offset = n
+	}
+
+	return limit, offset, nil
+}
+
+// parseTimeParam reads an RFC 3339 timestamp or YYYY-MM-DD date query parameter.
+// When endOfDay is set, a plain date covers the whole day, so "to=2024-05-31" includes May 31st.
+func parseTimeParam(req *http.Request, name string, endOfDay bool) (*time.Time, error) {
+	v := req.URL.Query().Get(name)
+	if v == "" {
+		return nil, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return &t, nil
+	}
+
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a date (YYYY-MM-DD) or RFC 3339 timestamp", name)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+
+	return &t, nil
+}
+
 // handleGetLogs handles GET /api/logs
 func (r *Router) handleGetLogs(w http.ResponseWriter, req *http.Request) {
 	// Get logs
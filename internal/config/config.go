@@ -3,6 +3,7 @@ package config
 import (
 	"errors"
 	"os"
+	"strconv"
 
 	"github.com/joho/godotenv"
 )
@@ -23,6 +24,16 @@ type Config struct {
 	// Twitch API configuration
 	TwitchClientID     string
 	TwitchClientSecret string
+	TwitchAPIBaseURL   string
+	TwitchAuthBaseURL  string
+	TwitchSimulate     bool // Run against a local fake Twitch API instead of twitch.tv
+
+	// Twitch EventSub configuration
+	TwitchEventSubTransport   string
+	TwitchEventSubCallbackURL string
+	TwitchEventSubSecret      string
+	TwitchEventSubWSURL       string
+	TwitchUserAccessToken     string
 
 	// Discord configuration
 	DiscordBotToken string
@@ -32,6 +43,16 @@ type Config struct {
 	TwitterAPISecret    string
 	TwitterAccessToken  string
 	TwitterAccessSecret string
+
+	// Telegram configuration
+	TelegramBotToken string
+
+	// Notification delivery configuration
+	NotificationMaxAttempts int
+
+	// Stream timeline retention, in days (0 keeps samples forever)
+	StreamSampleDownsampleDays int // Age after which samples are averaged into 5 minute buckets
+	StreamSampleRetentionDays  int // Age after which samples are deleted
 }
 
 // LoadConfig loads the configuration from environment variables
@@ -55,6 +76,16 @@ func LoadConfig() (*Config, error) {
 		// Twitch API configuration
 		TwitchClientID:     getEnv("TWITCH_CLIENT_ID", ""),
 		TwitchClientSecret: getEnv("TWITCH_CLIENT_SECRET", ""),
+		TwitchAPIBaseURL:   getEnv("TWITCH_API_BASE_URL", "https://api.twitch.tv/helix"),
+		TwitchAuthBaseURL:  getEnv("TWITCH_AUTH_BASE_URL", "https://id.twitch.tv/oauth2"),
+		TwitchSimulate:     getEnvBool("TWITCH_SIMULATE", false),
+
+		// Twitch EventSub configuration
+		TwitchEventSubTransport:   getEnv("TWITCH_EVENTSUB_TRANSPORT", ""),
+		TwitchEventSubCallbackURL: getEnv("TWITCH_EVENTSUB_CALLBACK_URL", ""),
+		TwitchEventSubSecret:      getEnv("TWITCH_EVENTSUB_SECRET", ""),
+		TwitchEventSubWSURL:       getEnv("TWITCH_EVENTSUB_WS_URL", "wss://eventsub.wss.twitch.tv/ws"),
+		TwitchUserAccessToken:     getEnv("TWITCH_USER_ACCESS_TOKEN", ""),
 
 		// Discord configuration
 		DiscordBotToken: getEnv("DISCORD_BOT_TOKEN", ""),
@@ -64,6 +95,26 @@ func LoadConfig() (*Config, error) {
 		TwitterAPISecret:    getEnv("TWITTER_API_SECRET", ""),
 		TwitterAccessToken:  getEnv("TWITTER_ACCESS_TOKEN", ""),
 		TwitterAccessSecret: getEnv("TWITTER_ACCESS_SECRET", ""),
+
+		// Telegram configuration
+		TelegramBotToken: getEnv("TELEGRAM_BOT_TOKEN", ""),
+
+		// Notification delivery configuration
+		NotificationMaxAttempts: getEnvInt("NOTIFICATION_MAX_ATTEMPTS", 8),
+
+		// Stream timeline retention
+		StreamSampleDownsampleDays: getEnvInt("STREAM_SAMPLE_DOWNSAMPLE_DAYS", 7),
+		StreamSampleRetentionDays:  getEnvInt("STREAM_SAMPLE_RETENTION_DAYS", 365),
+	}
+
+	// A callback URL alone implies the webhook transport
+	if cfg.TwitchEventSubTransport == "" && cfg.TwitchEventSubCallbackURL != "" {
+		cfg.TwitchEventSubTransport = "webhook"
+	}
+
+	// The simulated Twitch API only supports polling
+	if cfg.TwitchSimulate {
+		cfg.TwitchEventSubTransport = ""
 	}
 
 	// Validate required configuration
@@ -81,18 +132,40 @@ func (c *Config) validate() error {
 		return errors.New("database configuration is required")
 	}
 
-	// Twitch API configuration is required
-	if c.TwitchClientID == "" || c.TwitchClientSecret == "" {
+	// Twitch API configuration is required, unless the API is simulated
+	if !c.TwitchSimulate && (c.TwitchClientID == "" || c.TwitchClientSecret == "") {
 		return errors.New("Twitch API configuration is required")
 	}
 
-	// At least one notification method is required
-	hasDiscord := c.DiscordBotToken != ""
-	hasTwitter := c.TwitterAPIKey != "" && c.TwitterAPISecret != "" && 
-		c.TwitterAccessToken != "" && c.TwitterAccessSecret != ""
+	// Check EventSub transport configuration
+	switch c.TwitchEventSubTransport {
+	case "":
+		// Polling only
+	case "webhook":
+		// EventSub webhooks need a callback and a signing secret that Twitch accepts (10-100 characters)
+		if c.TwitchEventSubCallbackURL == "" {
+			return errors.New("TWITCH_EVENTSUB_CALLBACK_URL is required for the webhook transport")
+		}
+		if len(c.TwitchEventSubSecret) < 10 || len(c.TwitchEventSubSecret) > 100 {
+			return errors.New("TWITCH_EVENTSUB_SECRET must be between 10 and 100 characters when EventSub is enabled")
+		}
+	case "websocket":
+		// Twitch only accepts WebSocket subscriptions created with a user access token
+		if c.TwitchUserAccessToken == "" {
+			return errors.New("TWITCH_USER_ACCESS_TOKEN is required for the websocket transport")
+		}
+	default:
+		return errors.New("TWITCH_EVENTSUB_TRANSPORT must be webhook or websocket")
+	}
+
+	// Deliveries need at least one attempt
+	if c.NotificationMaxAttempts < 1 {
+		return errors.New("NOTIFICATION_MAX_ATTEMPTS must be at least 1")
+	}
 
-	if !hasDiscord && !hasTwitter {
-		return errors.New("at least one notification method (Discord or Twitter) is required")
+	// Sample ages cannot be negative
+	if c.StreamSampleDownsampleDays < 0 || c.StreamSampleRetentionDays < 0 {
+		return errors.New("STREAM_SAMPLE_DOWNSAMPLE_DAYS and STREAM_SAMPLE_RETENTION_DAYS cannot be negative")
 	}
 
 	return nil
@@ -105,4 +178,22 @@ func getEnv(key, defaultValue string) string {
 		return defaultValue
 	}
 	return value
-}
\ No newline at end of file
+}
+
+// getEnvInt gets an integer environment variable or returns a default value
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvBool gets a boolean environment variable or returns a default value
+func getEnvBool(key string, defaultValue bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
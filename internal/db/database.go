@@ -2,6 +2,7 @@ package db
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"path/filepath"
 
@@ -11,7 +12,7 @@ import (
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
 	"github.com/golang-migrate/migrate/v4/source/file"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 // Database represents a database connection
@@ -83,9 +84,45 @@ func (d *Database) Migrate() error {
 	return nil
 }
 
+// streamerColumns lists the streamer columns in the order scanStreamer expects
+const streamerColumns = `id, twitch_user_id, username, display_name, is_live, last_stream_start, last_stream_end,
+	last_notification_sent, peak_viewers, last_stream_title, last_game_name,
+	notification_cooldown_seconds, offline_grace_seconds, last_stream_id, offline_since,
+	milestone_thresholds, record_viewers, record_viewers_at_start, offline_notified`
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanStreamer scans a streamer selected with streamerColumns
+func scanStreamer(row rowScanner, s *models.Streamer) error {
+	return row.Scan(
+		&s.ID,
+		&s.TwitchUserID,
+		&s.Username,
+		&s.DisplayName,
+		&s.IsLive,
+		&s.LastStreamStart,
+		&s.LastStreamEnd,
+		&s.LastNotificationSent,
+		&s.PeakViewers,
+		&s.LastStreamTitle,
+		&s.LastGameName,
+		&s.NotificationCooldownSeconds,
+		&s.OfflineGraceSeconds,
+		&s.LastStreamID,
+		&s.OfflineSince,
+		pq.Array(&s.MilestoneThresholds),
+		&s.RecordViewers,
+		&s.RecordViewersAtStart,
+		&s.OfflineNotified,
+	)
+}
+
 // GetStreamers returns all streamers from the database
 func (d *Database) GetStreamers() ([]models.Streamer, error) {
-	rows, err := d.db.Query("SELECT id, username, display_name, is_live, last_stream_start, last_notification_sent FROM streamers")
+	rows, err := d.db.Query("SELECT " + streamerColumns + " FROM streamers")
 	if err != nil {
 		return nil, errors.NewDatabaseError("Failed to query streamers", err)
 	}
@@ -94,7 +131,7 @@ func (d *Database) GetStreamers() ([]models.Streamer, error) {
 	var streamers []models.Streamer
 	for rows.Next() {
 		var s models.Streamer
-		if err := rows.Scan(&s.ID, &s.Username, &s.DisplayName, &s.IsLive, &s.LastStreamStart, &s.LastNotificationSent); err != nil {
+		if err := scanStreamer(rows, &s); err != nil {
 			return nil, errors.NewDatabaseError("Failed to scan streamer row", err)
 		}
 		streamers = append(streamers, s)
@@ -107,22 +144,76 @@ func (d *Database) GetStreamers() ([]models.Streamer, error) {
 	return streamers, nil
 }
 
+// GetStreamer returns the streamer with the given ID
+func (d *Database) GetStreamer(id int) (*models.Streamer, error) {
+	var s models.Streamer
+	row := d.db.QueryRow("SELECT "+streamerColumns+" FROM streamers WHERE id = $1", id)
+	err := scanStreamer(row, &s)
+
+	if err == sql.ErrNoRows {
+		return nil, errors.NewNotFoundError("Streamer not found", nil)
+	}
+	if err != nil {
+		return nil, errors.NewDatabaseError("Failed to query streamer", err)
+	}
+
+	return &s, nil
+}
+
+// GetStreamerByUsername returns the streamer with the given Twitch login
+func (d *Database) GetStreamerByUsername(username string) (*models.Streamer, error) {
+	var s models.Streamer
+	row := d.db.QueryRow("SELECT "+streamerColumns+" FROM streamers WHERE username = $1", username)
+	err := scanStreamer(row, &s)
+
+	if err == sql.ErrNoRows {
+		return nil, errors.NewNotFoundError("Streamer not found", nil)
+	}
+	if err != nil {
+		return nil, errors.NewDatabaseError("Failed to query streamer", err)
+	}
+
+	return &s, nil
+}
+
+// GetStreamerByTwitchUserID returns the streamer with the given Twitch user ID
+func (d *Database) GetStreamerByTwitchUserID(twitchUserID string) (*models.Streamer, error) {
+	if twitchUserID == "" {
+		return nil, errors.NewNotFoundError("Streamer not found", nil)
+	}
+
+	var s models.Streamer
+	row := d.db.QueryRow("SELECT "+streamerColumns+" FROM streamers WHERE twitch_user_id = $1", twitchUserID)
+	err := scanStreamer(row, &s)
+
+	if err == sql.ErrNoRows {
+		return nil, errors.NewNotFoundError("Streamer not found", nil)
+	}
+	if err != nil {
+		return nil, errors.NewDatabaseError("Failed to query streamer", err)
+	}
+
+	return &s, nil
+}
+
 // AddStreamer adds a new streamer to the database
 func (d *Database) AddStreamer(streamer *models.Streamer) error {
 	query := `
-		INSERT INTO streamers (username, display_name, is_live, last_stream_start, last_notification_sent)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id
+		INSERT INTO streamers (twitch_user_id, username, display_name, is_live, last_stream_start, last_notification_sent)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, notification_cooldown_seconds, offline_grace_seconds, milestone_thresholds, record_viewers
 	`
 
 	err := d.db.QueryRow(
 		query,
+		streamer.TwitchUserID,
 		streamer.Username,
 		streamer.DisplayName,
 		streamer.IsLive,
 		streamer.LastStreamStart,
 		streamer.LastNotificationSent,
-	).Scan(&streamer.ID)
+	).Scan(&streamer.ID, &streamer.NotificationCooldownSeconds, &streamer.OfflineGraceSeconds,
+		pq.Array(&streamer.MilestoneThresholds), &streamer.RecordViewers)
 
 	if err != nil {
 		return errors.NewDatabaseError("Failed to add streamer", err)
@@ -131,21 +222,42 @@ func (d *Database) AddStreamer(streamer *models.Streamer) error {
 	return nil
 }
 
-// UpdateStreamer updates a streamer in the database
+// execer is implemented by *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// UpdateStreamer updates a streamer's state in the database. Its identity is only
+// written by UpdateStreamerIdentity, so a state update cannot undo a rename.
 func (d *Database) UpdateStreamer(streamer *models.Streamer) error {
+	return updateStreamer(d.db, streamer)
+}
+
+// updateStreamer updates a streamer using the given connection or transaction
+func updateStreamer(exec execer, streamer *models.Streamer) error {
 	query := `
 		UPDATE streamers
-		SET username = $1, display_name = $2, is_live = $3, last_stream_start = $4, last_notification_sent = $5
-		WHERE id = $6
+		SET is_live = $1, last_stream_start = $2, last_stream_end = $3,
+			last_notification_sent = $4, peak_viewers = $5, last_stream_title = $6, last_game_name = $7,
+			last_stream_id = $8, offline_since = $9, record_viewers = $10, record_viewers_at_start = $11,
+			offline_notified = $12
+		WHERE id = $13
 	`
 
-	result, err := d.db.Exec(
+	result, err := exec.Exec(
 		query,
-		streamer.Username,
-		streamer.DisplayName,
 		streamer.IsLive,
 		streamer.LastStreamStart,
+		streamer.LastStreamEnd,
 		streamer.LastNotificationSent,
+		streamer.PeakViewers,
+		streamer.LastStreamTitle,
+		streamer.LastGameName,
+		streamer.LastStreamID,
+		streamer.OfflineSince,
+		streamer.RecordViewers,
+		streamer.RecordViewersAtStart,
+		streamer.OfflineNotified,
 		streamer.ID,
 	)
 
@@ -165,6 +277,70 @@ func (d *Database) UpdateStreamer(streamer *models.Streamer) error {
 	return nil
 }
 
+// UpdateStreamerIdentity updates a streamer's Twitch user ID, login and display name
+func (d *Database) UpdateStreamerIdentity(streamer *models.Streamer) error {
+	query := `
+		UPDATE streamers
+		SET twitch_user_id = $1, username = $2, display_name = $3
+		WHERE id = $4
+	`
+
+	result, err := d.db.Exec(
+		query,
+		streamer.TwitchUserID,
+		streamer.Username,
+		streamer.DisplayName,
+		streamer.ID,
+	)
+
+	if err != nil {
+		return errors.NewDatabaseError("Failed to update streamer identity", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return errors.NewDatabaseError("Failed to get rows affected", err)
+	}
+
+	if rowsAffected == 0 {
+		return errors.NewNotFoundError("Streamer not found", nil)
+	}
+
+	return nil
+}
+
+// UpdateStreamerSettings updates a streamer's flap suppression and milestone settings
+func (d *Database) UpdateStreamerSettings(streamer *models.Streamer) error {
+	query := `
+		UPDATE streamers
+		SET notification_cooldown_seconds = $1, offline_grace_seconds = $2, milestone_thresholds = $3
+		WHERE id = $4
+	`
+
+	result, err := d.db.Exec(
+		query,
+		streamer.NotificationCooldownSeconds,
+		streamer.OfflineGraceSeconds,
+		pq.Array(streamer.MilestoneThresholds),
+		streamer.ID,
+	)
+
+	if err != nil {
+		return errors.NewDatabaseError("Failed to update streamer settings", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return errors.NewDatabaseError("Failed to get rows affected", err)
+	}
+
+	if rowsAffected == 0 {
+		return errors.NewNotFoundError("Streamer not found", nil)
+	}
+
+	return nil
+}
+
 // DeleteStreamer deletes a streamer from the database
 func (d *Database) DeleteStreamer(id int) error {
 	result, err := d.db.Exec("DELETE FROM streamers WHERE id = $1", id)
@@ -184,9 +360,67 @@ func (d *Database) DeleteStreamer(id int) error {
 	return nil
 }
 
+// notificationColumns lists the notification setting columns in the order scanNotificationSetting expects
+const notificationColumns = `notification_settings.id, notification_settings.type, notification_settings.destination,
+	notification_settings.enabled, notification_settings.event_types, notification_settings.all_streamers,
+	notification_settings.templates, notification_settings.filters, notification_settings.headers,
+	notification_settings.secret, notification_settings.mention`
+
+// scanNotificationSetting scans a notification setting selected with notificationColumns
+func scanNotificationSetting(row rowScanner, s *models.NotificationSetting) error {
+	var templates, filters, headers []byte
+	err := row.Scan(
+		&s.ID,
+		&s.Type,
+		&s.Destination,
+		&s.Enabled,
+		pq.Array(&s.EventTypes),
+		&s.AllStreamers,
+		&templates,
+		&filters,
+		&headers,
+		&s.Secret,
+		&s.Mention,
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(templates, &s.Templates); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(headers, &s.Headers); err != nil {
+		return err
+	}
+	return json.Unmarshal(filters, &s.Filters)
+}
+
 // GetNotificationSettings returns all notification settings from the database
 func (d *Database) GetNotificationSettings() ([]models.NotificationSetting, error) {
-	rows, err := d.db.Query("SELECT id, type, destination, enabled FROM notification_settings")
+	return d.queryNotificationSettings("SELECT " + notificationColumns + " FROM notification_settings ORDER BY id")
+}
+
+// GetNotificationSettingsForStreamer returns the notification settings that apply to a streamer,
+// either because they cover all streamers or because the streamer is subscribed to them
+func (d *Database) GetNotificationSettingsForStreamer(streamerID int) ([]models.NotificationSetting, error) {
+	query := `
+		SELECT ` + notificationColumns + `
+		FROM notification_settings
+		WHERE notification_settings.all_streamers
+			OR EXISTS (
+				SELECT 1 FROM streamer_notifications
+				WHERE streamer_notifications.notification_setting_id = notification_settings.id
+					AND streamer_notifications.streamer_id = $1
+			)
+		ORDER BY notification_settings.id
+	`
+
+	return d.queryNotificationSettings(query, streamerID)
+}
+
+// queryNotificationSettings runs a query selecting notificationColumns
+func (d *Database) queryNotificationSettings(query string, args ...interface{}) ([]models.NotificationSetting, error) {
+	rows, err := d.db.Query(query, args...)
 	if err != nil {
 		return nil, errors.NewDatabaseError("Failed to query notification settings", err)
 	}
@@ -195,7 +429,7 @@ func (d *Database) GetNotificationSettings() ([]models.NotificationSetting, erro
 	var settings []models.NotificationSetting
 	for rows.Next() {
 		var s models.NotificationSetting
-		if err := rows.Scan(&s.ID, &s.Type, &s.Destination, &s.Enabled); err != nil {
+		if err := scanNotificationSetting(rows, &s); err != nil {
 			return nil, errors.NewDatabaseError("Failed to scan notification setting row", err)
 		}
 		settings = append(settings, s)
@@ -208,19 +442,57 @@ func (d *Database) GetNotificationSettings() ([]models.NotificationSetting, erro
 	return settings, nil
 }
 
+// GetNotificationSetting returns a single notification setting
+func (d *Database) GetNotificationSetting(id int) (*models.NotificationSetting, error) {
+	var s models.NotificationSetting
+	row := d.db.QueryRow("SELECT "+notificationColumns+" FROM notification_settings WHERE id = $1", id)
+	err := scanNotificationSetting(row, &s)
+
+	if err == sql.ErrNoRows {
+		return nil, errors.NewNotFoundError("Notification setting not found", nil)
+	}
+	if err != nil {
+		return nil, errors.NewDatabaseError("Failed to query notification setting", err)
+	}
+
+	return &s, nil
+}
+
 // AddNotificationSetting adds a new notification setting to the database
 func (d *Database) AddNotificationSetting(setting *models.NotificationSetting) error {
 	query := `
-		INSERT INTO notification_settings (type, destination, enabled)
-		VALUES ($1, $2, $3)
+		INSERT INTO notification_settings (type, destination, enabled, event_types, all_streamers, templates, filters,
+			headers, secret, mention)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 		RETURNING id
 	`
 
-	err := d.db.QueryRow(
+	setting.EventTypes = eventTypesOrDefault(setting.EventTypes)
+	templates, err := marshalTemplates(setting.Templates)
+	if err != nil {
+		return err
+	}
+	filters, err := marshalFilters(setting.Filters)
+	if err != nil {
+		return err
+	}
+	headers, err := marshalHeaders(setting.Headers)
+	if err != nil {
+		return err
+	}
+
+	err = d.db.QueryRow(
 		query,
 		setting.Type,
 		setting.Destination,
 		setting.Enabled,
+		pq.Array(setting.EventTypes),
+		setting.AllStreamers,
+		templates,
+		filters,
+		headers,
+		setting.Secret,
+		setting.Mention,
 	).Scan(&setting.ID)
 
 	if err != nil {
@@ -234,15 +506,37 @@ func (d *Database) AddNotificationSetting(setting *models.NotificationSetting) e
 func (d *Database) UpdateNotificationSetting(setting *models.NotificationSetting) error {
 	query := `
 		UPDATE notification_settings
-		SET type = $1, destination = $2, enabled = $3
-		WHERE id = $4
+		SET type = $1, destination = $2, enabled = $3, event_types = $4, all_streamers = $5, templates = $6,
+			filters = $7, headers = $8, secret = $9, mention = $10
+		WHERE id = $11
 	`
 
+	setting.EventTypes = eventTypesOrDefault(setting.EventTypes)
+	templates, err := marshalTemplates(setting.Templates)
+	if err != nil {
+		return err
+	}
+	filters, err := marshalFilters(setting.Filters)
+	if err != nil {
+		return err
+	}
+	headers, err := marshalHeaders(setting.Headers)
+	if err != nil {
+		return err
+	}
+
 	result, err := d.db.Exec(
 		query,
 		setting.Type,
 		setting.Destination,
 		setting.Enabled,
+		pq.Array(setting.EventTypes),
+		setting.AllStreamers,
+		templates,
+		filters,
+		headers,
+		setting.Secret,
+		setting.Mention,
 		setting.ID,
 	)
 
@@ -280,3 +574,52 @@ func (d *Database) DeleteNotificationSetting(id int) error {
 
 	return nil
 }
+
+// eventTypesOrDefault returns the event types, defaulting to go-live notifications only
+func eventTypesOrDefault(eventTypes []string) []string {
+	if len(eventTypes) == 0 {
+		return []string{models.EventTypeLive}
+	}
+	return eventTypes
+}
+
+// marshalTemplates encodes message templates for storage, dropping empty templates
+func marshalTemplates(templates map[string]string) ([]byte, error) {
+	stored := make(map[string]string)
+	for eventType, text := range templates {
+		if text != "" {
+			stored[eventType] = text
+		}
+	}
+
+	payload, err := json.Marshal(stored)
+	if err != nil {
+		return nil, errors.NewInternalError("Failed to marshal notification templates", err)
+	}
+
+	return payload, nil
+}
+
+// marshalFilters encodes notification filters for storage
+func marshalFilters(filters models.NotificationFilters) ([]byte, error) {
+	payload, err := json.Marshal(filters)
+	if err != nil {
+		return nil, errors.NewInternalError("Failed to marshal notification filters", err)
+	}
+
+	return payload, nil
+}
+
+// marshalHeaders encodes the custom request headers of a webhook destination for storage
+func marshalHeaders(headers map[string]string) ([]byte, error) {
+	if headers == nil {
+		headers = map[string]string{}
+	}
+
+	payload, err := json.Marshal(headers)
+	if err != nil {
+		return nil, errors.NewInternalError("Failed to marshal notification headers", err)
+	}
+
+	return payload, nil
+}
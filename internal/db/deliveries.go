@@ -0,0 +1,141 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/drmaq/streamnotification/internal/errors"
+	"github.com/drmaq/streamnotification/internal/models"
+)
+
+// deliveryColumns lists the delivery columns in the order scanDelivery expects
+const deliveryColumns = `id, outbox_id, streamer_id, streamer_name, notification_setting_id, notification_type,
+	destination, event_type, attempt, status, status_code, error, latency_ms, message_id, created_at`
+
+// scanDelivery scans a delivery selected with deliveryColumns
+func scanDelivery(row rowScanner, d *models.Delivery) error {
+	return row.Scan(
+		&d.ID,
+		&d.OutboxID,
+		&d.StreamerID,
+		&d.StreamerName,
+		&d.NotificationSettingID,
+		&d.NotificationType,
+		&d.Destination,
+		&d.EventType,
+		&d.Attempt,
+		&d.Status,
+		&d.StatusCode,
+		&d.Error,
+		&d.LatencyMS,
+		&d.MessageID,
+		&d.CreatedAt,
+	)
+}
+
+// DeliveryFilter selects deliveries for the audit trail. Zero values match everything.
+type DeliveryFilter struct {
+	StreamerID            int
+	NotificationSettingID int
+	OutboxID              int
+	Status                models.DeliveryStatus
+	EventType             string
+	From                  *time.Time
+	To                    *time.Time
+	Limit                 int
+	Offset                int
+}
+
+// RecordDelivery stores a delivery attempt
+func (d *Database) RecordDelivery(delivery *models.Delivery) error {
+	query := `
+		INSERT INTO deliveries (outbox_id, streamer_id, streamer_name, notification_setting_id, notification_type,
+			destination, event_type, attempt, status, status_code, error, latency_ms, message_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING id, created_at
+	`
+
+	err := d.db.QueryRow(
+		query,
+		delivery.OutboxID,
+		delivery.StreamerID,
+		delivery.StreamerName,
+		delivery.NotificationSettingID,
+		delivery.NotificationType,
+		delivery.Destination,
+		delivery.EventType,
+		delivery.Attempt,
+		delivery.Status,
+		delivery.StatusCode,
+		delivery.Error,
+		delivery.LatencyMS,
+		delivery.MessageID,
+	).Scan(&delivery.ID, &delivery.CreatedAt)
+
+	if err != nil {
+		return errors.NewDatabaseError("Failed to record delivery", err)
+	}
+
+	return nil
+}
+
+// GetDeliveries returns delivery attempts matching the filter, newest first
+func (d *Database) GetDeliveries(filter DeliveryFilter) ([]models.Delivery, error) {
+	var conditions []string
+	var args []interface{}
+	where := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.StreamerID != 0 {
+		where("streamer_id = $%d", filter.StreamerID)
+	}
+	if filter.NotificationSettingID != 0 {
+		where("notification_setting_id = $%d", filter.NotificationSettingID)
+	}
+	if filter.OutboxID != 0 {
+		where("outbox_id = $%d", filter.OutboxID)
+	}
+	if filter.Status != "" {
+		where("status = $%d", filter.Status)
+	}
+	if filter.EventType != "" {
+		where("event_type = $%d", filter.EventType)
+	}
+	if filter.From != nil {
+		where("created_at >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		where("created_at < $%d", *filter.To)
+	}
+
+	query := "SELECT " + deliveryColumns + " FROM deliveries"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, filter.Limit, filter.Offset)
+	query += fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, errors.NewDatabaseError("Failed to query deliveries", err)
+	}
+	defer rows.Close()
+
+	deliveries := []models.Delivery{}
+	for rows.Next() {
+		var delivery models.Delivery
+		if err := scanDelivery(rows, &delivery); err != nil {
+			return nil, errors.NewDatabaseError("Failed to scan delivery row", err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.NewDatabaseError("Error iterating delivery rows", err)
+	}
+
+	return deliveries, nil
+}
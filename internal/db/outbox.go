@@ -0,0 +1,212 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/drmaq/streamnotification/internal/errors"
+	"github.com/drmaq/streamnotification/internal/models"
+)
+
+// outboxColumns lists the outbox columns in the order scanOutboxEntry expects
+const outboxColumns = `id, notification_setting_id, COALESCE(streamer_id, 0), event_type, payload, status,
+	attempts, next_attempt_at, last_error, created_at, updated_at, sent_at`
+
+// scanOutboxEntry scans an outbox entry selected with outboxColumns
+func scanOutboxEntry(row rowScanner, e *models.OutboxEntry) error {
+	var payload []byte
+	err := row.Scan(
+		&e.ID,
+		&e.NotificationSettingID,
+		&e.StreamerID,
+		&e.EventType,
+		&payload,
+		&e.Status,
+		&e.Attempts,
+		&e.NextAttemptAt,
+		&e.LastError,
+		&e.CreatedAt,
+		&e.UpdatedAt,
+		&e.SentAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	e.Event = &models.StreamEvent{}
+	return json.Unmarshal(payload, e.Event)
+}
+
+// UpdateStreamerAndEnqueue updates a streamer and queues its notifications in a single transaction,
+// so a state change is never recorded without the deliveries it triggered
+func (d *Database) UpdateStreamerAndEnqueue(streamer *models.Streamer, entries []models.OutboxEntry) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return errors.NewDatabaseError("Failed to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	if err := updateStreamer(tx, streamer); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO notification_outbox (notification_setting_id, streamer_id, event_type, payload)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, status, next_attempt_at, created_at, updated_at
+	`
+
+	for i := range entries {
+		payload, err := json.Marshal(entries[i].Event)
+		if err != nil {
+			return errors.NewInternalError("Failed to marshal outbox payload", err)
+		}
+
+		err = tx.QueryRow(
+			query,
+			entries[i].NotificationSettingID,
+			entries[i].StreamerID,
+			entries[i].EventType,
+			payload,
+		).Scan(&entries[i].ID, &entries[i].Status, &entries[i].NextAttemptAt, &entries[i].CreatedAt, &entries[i].UpdatedAt)
+
+		if err != nil {
+			return errors.NewDatabaseError("Failed to enqueue notification", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.NewDatabaseError("Failed to commit transaction", err)
+	}
+
+	return nil
+}
+
+// ClaimDueOutboxEntries returns pending entries that are due and leases them for the given
+// duration, so concurrent dispatchers do not deliver the same entry twice
+func (d *Database) ClaimDueOutboxEntries(limit int, lease time.Duration) ([]models.OutboxEntry, error) {
+	query := `
+		UPDATE notification_outbox
+		SET next_attempt_at = NOW() + $2 * INTERVAL '1 second', updated_at = NOW()
+		WHERE id IN (
+			SELECT id FROM notification_outbox
+			WHERE status = $3 AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + outboxColumns
+
+	rows, err := d.db.Query(query, limit, lease.Seconds(), models.OutboxStatusPending)
+	if err != nil {
+		return nil, errors.NewDatabaseError("Failed to claim outbox entries", err)
+	}
+	defer rows.Close()
+
+	var entries []models.OutboxEntry
+	for rows.Next() {
+		var e models.OutboxEntry
+		if err := scanOutboxEntry(rows, &e); err != nil {
+			return nil, errors.NewDatabaseError("Failed to scan outbox row", err)
+		}
+		entries = append(entries, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.NewDatabaseError("Error iterating outbox rows", err)
+	}
+
+	return entries, nil
+}
+
+// MarkOutboxSent records a successful delivery
+func (d *Database) MarkOutboxSent(id, attempts int) error {
+	_, err := d.db.Exec(`
+		UPDATE notification_outbox
+		SET status = $1, attempts = $2, last_error = '', sent_at = NOW(), updated_at = NOW()
+		WHERE id = $3
+	`, models.OutboxStatusSent, attempts, id)
+
+	if err != nil {
+		return errors.NewDatabaseError("Failed to mark outbox entry as sent", err)
+	}
+	return nil
+}
+
+// MarkOutboxRetry records a failed delivery and schedules the next attempt
+func (d *Database) MarkOutboxRetry(id, attempts int, delay time.Duration, lastError string) error {
+	_, err := d.db.Exec(`
+		UPDATE notification_outbox
+		SET attempts = $1, next_attempt_at = NOW() + $2 * INTERVAL '1 second', last_error = $3, updated_at = NOW()
+		WHERE id = $4
+	`, attempts, delay.Seconds(), lastError, id)
+
+	if err != nil {
+		return errors.NewDatabaseError("Failed to reschedule outbox entry", err)
+	}
+	return nil
+}
+
+// MarkOutboxDead moves an entry to the dead-letter state
+func (d *Database) MarkOutboxDead(id, attempts int, lastError string) error {
+	_, err := d.db.Exec(`
+		UPDATE notification_outbox
+		SET status = $1, attempts = $2, last_error = $3, updated_at = NOW()
+		WHERE id = $4
+	`, models.OutboxStatusDead, attempts, lastError, id)
+
+	if err != nil {
+		return errors.NewDatabaseError("Failed to dead-letter outbox entry", err)
+	}
+	return nil
+}
+
+// GetDeadLetters returns dead-lettered entries, most recent first
+func (d *Database) GetDeadLetters(limit, offset int) ([]models.OutboxEntry, error) {
+	rows, err := d.db.Query(
+		"SELECT "+outboxColumns+" FROM notification_outbox WHERE status = $1 ORDER BY updated_at DESC LIMIT $2 OFFSET $3",
+		models.OutboxStatusDead, limit, offset,
+	)
+	if err != nil {
+		return nil, errors.NewDatabaseError("Failed to query dead letters", err)
+	}
+	defer rows.Close()
+
+	entries := []models.OutboxEntry{}
+	for rows.Next() {
+		var e models.OutboxEntry
+		if err := scanOutboxEntry(rows, &e); err != nil {
+			return nil, errors.NewDatabaseError("Failed to scan outbox row", err)
+		}
+		entries = append(entries, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.NewDatabaseError("Error iterating outbox rows", err)
+	}
+
+	return entries, nil
+}
+
+// ReplayDeadLetter moves a dead-lettered entry back to pending with a fresh set of attempts
+func (d *Database) ReplayDeadLetter(id int) (*models.OutboxEntry, error) {
+	var e models.OutboxEntry
+	row := d.db.QueryRow(`
+		UPDATE notification_outbox
+		SET status = $1, attempts = 0, next_attempt_at = NOW(), updated_at = NOW()
+		WHERE id = $2 AND status = $3
+		RETURNING `+outboxColumns,
+		models.OutboxStatusPending, id, models.OutboxStatusDead,
+	)
+
+	err := scanOutboxEntry(row, &e)
+	if err == sql.ErrNoRows {
+		return nil, errors.NewNotFoundError("Dead letter not found", nil)
+	}
+	if err != nil {
+		return nil, errors.NewDatabaseError("Failed to replay dead letter", err)
+	}
+
+	return &e, nil
+}
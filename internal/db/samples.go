@@ -0,0 +1,106 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/drmaq/streamnotification/internal/errors"
+	"github.com/drmaq/streamnotification/internal/models"
+)
+
+// sampleBucketStart is the SQL expression rounding a timestamp down to a multiple of $2 seconds
+const sampleBucketStart = `TIMESTAMP 'epoch' + make_interval(secs => FLOOR(EXTRACT(EPOCH FROM %s) / $2::integer) * $2::integer)`
+
+// insertStreamSample adds a sample to a session's timeline
+func insertStreamSample(exec execer, sessionID, viewerCount int, title, gameName string) error {
+	_, err := exec.Exec(`
+		INSERT INTO stream_samples (session_id, viewer_count, title, game_name)
+		VALUES ($1, $2, $3, $4)
+	`, sessionID, viewerCount, title, gameName)
+
+	if err != nil {
+		return errors.NewDatabaseError("Failed to add stream sample", err)
+	}
+
+	return nil
+}
+
+// GetStreamSamples returns a session's timeline, oldest sample first
+func (d *Database) GetStreamSamples(sessionID int) ([]models.StreamSample, error) {
+	rows, err := d.db.Query(`
+		SELECT sampled_at, viewer_count, title, game_name, bucket_seconds
+		FROM stream_samples
+		WHERE session_id = $1
+		ORDER BY sampled_at
+	`, sessionID)
+	if err != nil {
+		return nil, errors.NewDatabaseError("Failed to query stream samples", err)
+	}
+	defer rows.Close()
+
+	samples := []models.StreamSample{}
+	for rows.Next() {
+		var s models.StreamSample
+		if err := rows.Scan(&s.SampledAt, &s.ViewerCount, &s.Title, &s.GameName, &s.BucketSeconds); err != nil {
+			return nil, errors.NewDatabaseError("Failed to scan stream sample row", err)
+		}
+		samples = append(samples, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.NewDatabaseError("Error iterating stream sample rows", err)
+	}
+
+	return samples, nil
+}
+
+// DownsampleStreamSamples replaces the samples older than the given number of days
+// with one sample per bucket of bucketSeconds, averaging the viewer count and keeping
+// the last title and game. It returns the number of samples removed.
+func (d *Database) DownsampleStreamSamples(days, bucketSeconds int) (int64, error) {
+	cutoff := fmt.Sprintf(sampleBucketStart, "LOCALTIMESTAMP - make_interval(days => $1::integer)")
+	bucket := fmt.Sprintf(sampleBucketStart, "sampled_at")
+
+	var removed int64
+	err := d.db.QueryRow(`
+		WITH old AS (
+			DELETE FROM stream_samples
+			WHERE sampled_at < `+cutoff+` AND bucket_seconds < $2::integer
+			RETURNING session_id, sampled_at, viewer_count, title, game_name
+		), buckets AS (
+			INSERT INTO stream_samples (session_id, sampled_at, viewer_count, title, game_name, bucket_seconds)
+			SELECT session_id, `+bucket+` AS bucket, ROUND(AVG(viewer_count)),
+				(ARRAY_AGG(title ORDER BY sampled_at DESC))[1],
+				(ARRAY_AGG(game_name ORDER BY sampled_at DESC))[1],
+				$2::integer
+			FROM old
+			GROUP BY session_id, bucket
+			RETURNING id
+		)
+		SELECT (SELECT COUNT(*) FROM old) - (SELECT COUNT(*) FROM buckets)
+	`, days, bucketSeconds).Scan(&removed)
+
+	if err != nil {
+		return 0, errors.NewDatabaseError("Failed to downsample stream samples", err)
+	}
+
+	return removed, nil
+}
+
+// DeleteStreamSamples deletes the samples older than the given number of days.
+// The sessions themselves are kept.
+func (d *Database) DeleteStreamSamples(days int) (int64, error) {
+	result, err := d.db.Exec(`
+		DELETE FROM stream_samples
+		WHERE sampled_at < LOCALTIMESTAMP - make_interval(days => $1::integer)
+	`, days)
+	if err != nil {
+		return 0, errors.NewDatabaseError("Failed to delete stream samples", err)
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.NewDatabaseError("Failed to get rows affected", err)
+	}
+
+	return deleted, nil
+}
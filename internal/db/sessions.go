@@ -0,0 +1,336 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/drmaq/streamnotification/internal/errors"
+	"github.com/drmaq/streamnotification/internal/models"
+	"github.com/lib/pq"
+)
+
+// sessionColumns lists the stream session columns in the order scanStreamSession expects
+const sessionColumns = `id, streamer_id, stream_id, started_at, ended_at, title, game_name, thumbnail_url,
+	peak_viewers, CASE WHEN viewer_samples > 0 THEN viewer_total / viewer_samples ELSE 0 END`
+
+// scanStreamSession scans a stream session selected with sessionColumns
+func scanStreamSession(row rowScanner, s *models.StreamSession) error {
+	return row.Scan(
+		&s.ID,
+		&s.StreamerID,
+		&s.StreamID,
+		&s.StartedAt,
+		&s.EndedAt,
+		&s.Title,
+		&s.GameName,
+		&s.ThumbnailURL,
+		&s.PeakViewers,
+		&s.AverageViewers,
+	)
+}
+
+// SessionFilter selects stream sessions by start time
+type SessionFilter struct {
+	From   *time.Time
+	To     *time.Time
+	Limit  int
+	Offset int
+}
+
+// StartStreamSession records the start of a broadcast. Any session the streamer
+// still has open is closed first, so a missed offline never leaves two open sessions.
+func (d *Database) StartStreamSession(event *models.StreamEvent) (*models.StreamSession, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, errors.NewDatabaseError("Failed to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE stream_sessions
+		SET ended_at = GREATEST(started_at, $2), updated_at = NOW()
+		WHERE streamer_id = $1 AND ended_at IS NULL
+	`, event.StreamerID, event.StartedAt)
+	if err != nil {
+		return nil, errors.NewDatabaseError("Failed to close open stream session", err)
+	}
+
+	samples := 0
+	if event.ViewerCount > 0 {
+		samples = 1
+	}
+
+	session := &models.StreamSession{
+		StreamerID:     event.StreamerID,
+		StreamID:       event.StreamID,
+		StartedAt:      event.StartedAt,
+		Title:          event.StreamTitle,
+		GameName:       event.GameName,
+		ThumbnailURL:   event.ThumbnailURL,
+		PeakViewers:    event.ViewerCount,
+		AverageViewers: event.ViewerCount,
+		Changes:        []models.SessionChange{},
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO stream_sessions (streamer_id, stream_id, started_at, title, game_name, thumbnail_url,
+			peak_viewers, viewer_total, viewer_samples)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $7, $8)
+		RETURNING id
+	`,
+		session.StreamerID,
+		session.StreamID,
+		session.StartedAt,
+		session.Title,
+		session.GameName,
+		session.ThumbnailURL,
+		session.PeakViewers,
+		samples,
+	).Scan(&session.ID)
+	if err != nil {
+		return nil, errors.NewDatabaseError("Failed to add stream session", err)
+	}
+
+	// Start the timeline, unless the viewer count is not known yet
+	if samples > 0 {
+		if err := insertStreamSample(tx, session.ID, event.ViewerCount, session.Title, session.GameName); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.NewDatabaseError("Failed to commit transaction", err)
+	}
+
+	return session, nil
+}
+
+// ResumeStreamSession reopens the streamer's latest session, for a broadcast that
+// restarted and continues it. It returns a not found error when there is no session.
+func (d *Database) ResumeStreamSession(streamerID int) error {
+	result, err := d.db.Exec(`
+		UPDATE stream_sessions
+		SET ended_at = NULL, updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM stream_sessions
+			WHERE streamer_id = $1
+			ORDER BY started_at DESC
+			LIMIT 1
+		)
+	`, streamerID)
+	if err != nil {
+		return errors.NewDatabaseError("Failed to resume stream session", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return errors.NewDatabaseError("Failed to get rows affected", err)
+	}
+	if rowsAffected == 0 {
+		return errors.NewNotFoundError("No stream session to resume", nil)
+	}
+
+	return nil
+}
+
+// RecordStreamSample adds a viewer count sample to the streamer's open session and
+// records a change when the title or game differs from the session's current one
+func (d *Database) RecordStreamSample(event *models.StreamEvent) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return errors.NewDatabaseError("Failed to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	var id int
+	var title, gameName string
+	err = tx.QueryRow(`
+		SELECT id, title, game_name FROM stream_sessions
+		WHERE streamer_id = $1 AND ended_at IS NULL
+		FOR UPDATE
+	`, event.StreamerID).Scan(&id, &title, &gameName)
+
+	if err == sql.ErrNoRows {
+		return errors.NewNotFoundError("No open stream session", nil)
+	}
+	if err != nil {
+		return errors.NewDatabaseError("Failed to query open stream session", err)
+	}
+
+	_, err = tx.Exec(`
+		UPDATE stream_sessions
+		SET peak_viewers = GREATEST(peak_viewers, $2),
+			viewer_total = viewer_total + $2,
+			viewer_samples = viewer_samples + 1,
+			title = $3,
+			game_name = $4,
+			thumbnail_url = CASE WHEN $5 = '' THEN thumbnail_url ELSE $5 END,
+			updated_at = NOW()
+		WHERE id = $1
+	`, id, event.ViewerCount, valueOr(event.StreamTitle, title), valueOr(event.GameName, gameName), event.ThumbnailURL)
+	if err != nil {
+		return errors.NewDatabaseError("Failed to update stream session", err)
+	}
+
+	// Add the sample to the session's timeline
+	err = insertStreamSample(tx, id, event.ViewerCount, valueOr(event.StreamTitle, title), valueOr(event.GameName, gameName))
+	if err != nil {
+		return err
+	}
+
+	// Record title and game changes
+	changedTitle := event.StreamTitle != "" && event.StreamTitle != title
+	changedGame := event.GameName != "" && event.GameName != gameName
+	if changedTitle || changedGame {
+		_, err = tx.Exec(`
+			INSERT INTO stream_session_changes (session_id, title, game_name)
+			VALUES ($1, $2, $3)
+		`, id, valueOr(event.StreamTitle, title), valueOr(event.GameName, gameName))
+		if err != nil {
+			return errors.NewDatabaseError("Failed to add stream session change", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.NewDatabaseError("Failed to commit transaction", err)
+	}
+
+	return nil
+}
+
+// EndStreamSession closes the streamer's open session
+func (d *Database) EndStreamSession(streamerID int, endedAt time.Time) error {
+	_, err := d.db.Exec(`
+		UPDATE stream_sessions
+		SET ended_at = GREATEST(started_at, $2), updated_at = NOW()
+		WHERE streamer_id = $1 AND ended_at IS NULL
+	`, streamerID, endedAt)
+
+	if err != nil {
+		return errors.NewDatabaseError("Failed to end stream session", err)
+	}
+
+	return nil
+}
+
+// GetStreamSessions returns a streamer's sessions, newest first, with their title and game changes
+func (d *Database) GetStreamSessions(streamerID int, filter SessionFilter) ([]models.StreamSession, error) {
+	conditions := []string{"streamer_id = $1"}
+	args := []interface{}{streamerID}
+
+	if filter.From != nil {
+		args = append(args, *filter.From)
+		conditions = append(conditions, fmt.Sprintf("started_at >= $%d", len(args)))
+	}
+	if filter.To != nil {
+		args = append(args, *filter.To)
+		conditions = append(conditions, fmt.Sprintf("started_at < $%d", len(args)))
+	}
+
+	args = append(args, filter.Limit, filter.Offset)
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM stream_sessions
+		WHERE %s
+		ORDER BY started_at DESC
+		LIMIT $%d OFFSET $%d
+	`, sessionColumns, strings.Join(conditions, " AND "), len(args)-1, len(args))
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, errors.NewDatabaseError("Failed to query stream sessions", err)
+	}
+	defer rows.Close()
+
+	sessions := []models.StreamSession{}
+	for rows.Next() {
+		var s models.StreamSession
+		if err := scanStreamSession(rows, &s); err != nil {
+			return nil, errors.NewDatabaseError("Failed to scan stream session row", err)
+		}
+		sessions = append(sessions, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.NewDatabaseError("Error iterating stream session rows", err)
+	}
+
+	if err := d.attachSessionChanges(sessions); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// GetStreamSession returns one of a streamer's sessions with its title and game changes
+func (d *Database) GetStreamSession(streamerID, sessionID int) (*models.StreamSession, error) {
+	sessions := make([]models.StreamSession, 1)
+	row := d.db.QueryRow("SELECT "+sessionColumns+" FROM stream_sessions WHERE id = $1 AND streamer_id = $2",
+		sessionID, streamerID)
+	err := scanStreamSession(row, &sessions[0])
+
+	if err == sql.ErrNoRows {
+		return nil, errors.NewNotFoundError("Stream session not found", nil)
+	}
+	if err != nil {
+		return nil, errors.NewDatabaseError("Failed to query stream session", err)
+	}
+
+	if err := d.attachSessionChanges(sessions); err != nil {
+		return nil, err
+	}
+
+	return &sessions[0], nil
+}
+
+// attachSessionChanges adds their title and game changes to sessions
+func (d *Database) attachSessionChanges(sessions []models.StreamSession) error {
+	index := make(map[int]int)
+	ids := make([]int64, len(sessions))
+	for i := range sessions {
+		sessions[i].Changes = []models.SessionChange{}
+		index[sessions[i].ID] = i
+		ids[i] = int64(sessions[i].ID)
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	changeRows, err := d.db.Query(`
+		SELECT session_id, title, game_name, changed_at
+		FROM stream_session_changes
+		WHERE session_id = ANY($1)
+		ORDER BY changed_at
+	`, pq.Array(ids))
+	if err != nil {
+		return errors.NewDatabaseError("Failed to query stream session changes", err)
+	}
+	defer changeRows.Close()
+
+	for changeRows.Next() {
+		var sessionID int
+		var c models.SessionChange
+		if err := changeRows.Scan(&sessionID, &c.Title, &c.GameName, &c.ChangedAt); err != nil {
+			return errors.NewDatabaseError("Failed to scan stream session change row", err)
+		}
+		i := index[sessionID]
+		sessions[i].Changes = append(sessions[i].Changes, c)
+	}
+
+	if err := changeRows.Err(); err != nil {
+		return errors.NewDatabaseError("Error iterating stream session change rows", err)
+	}
+
+	return nil
+}
+
+// valueOr returns value, or fallback when value is empty
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,89 @@
+package db
+
+import (
+	"time"
+
+	"github.com/drmaq/streamnotification/internal/errors"
+	"github.com/drmaq/streamnotification/internal/models"
+	"github.com/lib/pq"
+)
+
+// GetSessionsBetween returns the sessions that started between from and to, oldest
+// first, for one streamer or for every streamer when streamerID is 0
+func (d *Database) GetSessionsBetween(streamerID int, from, to time.Time) ([]models.StreamSession, error) {
+	rows, err := d.db.Query(`
+		SELECT `+sessionColumns+`
+		FROM stream_sessions
+		WHERE ($1 = 0 OR streamer_id = $1) AND started_at >= $2 AND started_at < $3
+		ORDER BY started_at
+	`, streamerID, from, to)
+	if err != nil {
+		return nil, errors.NewDatabaseError("Failed to query stream sessions", err)
+	}
+	defer rows.Close()
+
+	sessions := []models.StreamSession{}
+	for rows.Next() {
+		var s models.StreamSession
+		if err := scanStreamSession(rows, &s); err != nil {
+			return nil, errors.NewDatabaseError("Failed to scan stream session row", err)
+		}
+		s.Changes = []models.SessionChange{}
+		sessions = append(sessions, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.NewDatabaseError("Error iterating stream session rows", err)
+	}
+
+	return sessions, nil
+}
+
+// GetSessionCategoryTime returns the time each session spent in each game, by session
+// ID, measured between consecutive samples of its timeline
+func (d *Database) GetSessionCategoryTime(sessions []models.StreamSession) (map[int]map[string]time.Duration, error) {
+	times := make(map[int]map[string]time.Duration)
+	if len(sessions) == 0 {
+		return times, nil
+	}
+
+	ids := make([]int64, len(sessions))
+	for i, session := range sessions {
+		ids[i] = int64(session.ID)
+	}
+
+	rows, err := d.db.Query(`
+		SELECT session_id, game_name, SUM(seconds)
+		FROM (
+			SELECT session_id, game_name,
+				EXTRACT(EPOCH FROM LEAD(sampled_at, 1, sampled_at) OVER (PARTITION BY session_id ORDER BY sampled_at) - sampled_at) AS seconds
+			FROM stream_samples
+			WHERE session_id = ANY($1)
+		) gaps
+		WHERE game_name <> ''
+		GROUP BY session_id, game_name
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, errors.NewDatabaseError("Failed to query stream sample categories", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sessionID int
+		var game string
+		var seconds float64
+		if err := rows.Scan(&sessionID, &game, &seconds); err != nil {
+			return nil, errors.NewDatabaseError("Failed to scan stream sample category row", err)
+		}
+		if times[sessionID] == nil {
+			times[sessionID] = make(map[string]time.Duration)
+		}
+		times[sessionID][game] = time.Duration(seconds * float64(time.Second))
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.NewDatabaseError("Error iterating stream sample category rows", err)
+	}
+
+	return times, nil
+}
@@ -0,0 +1,42 @@
+package db
+
+import (
+	"database/sql"
+
+	"github.com/drmaq/streamnotification/internal/errors"
+)
+
+// SaveStreamMessage records the message a destination received when a stream went live
+func (d *Database) SaveStreamMessage(settingID, streamerID int, streamID, messageID string) error {
+	_, err := d.db.Exec(`
+		INSERT INTO stream_messages (notification_setting_id, stream_id, streamer_id, message_id)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (notification_setting_id, stream_id) DO UPDATE
+		SET message_id = EXCLUDED.message_id, created_at = CURRENT_TIMESTAMP
+	`, settingID, streamID, streamerID, messageID)
+
+	if err != nil {
+		return errors.NewDatabaseError("Failed to save stream message", err)
+	}
+
+	return nil
+}
+
+// GetStreamMessageID returns the go-live message a destination received for a stream,
+// or an empty string when none was recorded
+func (d *Database) GetStreamMessageID(settingID int, streamID string) (string, error) {
+	var messageID string
+	err := d.db.QueryRow(`
+		SELECT message_id FROM stream_messages
+		WHERE notification_setting_id = $1 AND stream_id = $2
+	`, settingID, streamID).Scan(&messageID)
+
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.NewDatabaseError("Failed to query stream message", err)
+	}
+
+	return messageID, nil
+}
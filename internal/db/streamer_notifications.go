@@ -0,0 +1,89 @@
+package db
+
+import (
+	"github.com/drmaq/streamnotification/internal/errors"
+)
+
+// AddStreamerNotification subscribes a streamer to a notification destination
+func (d *Database) AddStreamerNotification(streamerID, settingID int) error {
+	// Check both sides exist so callers get a not found error instead of a constraint violation
+	if _, err := d.GetStreamer(streamerID); err != nil {
+		return err
+	}
+	if _, err := d.GetNotificationSetting(settingID); err != nil {
+		return err
+	}
+
+	_, err := d.db.Exec(`
+		INSERT INTO streamer_notifications (streamer_id, notification_setting_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`, streamerID, settingID)
+
+	if err != nil {
+		return errors.NewDatabaseError("Failed to add streamer notification", err)
+	}
+
+	return nil
+}
+
+// SetStreamerNotifications replaces the destinations a streamer is subscribed to
+func (d *Database) SetStreamerNotifications(streamerID int, settingIDs []int) error {
+	if _, err := d.GetStreamer(streamerID); err != nil {
+		return err
+	}
+	for _, settingID := range settingIDs {
+		if _, err := d.GetNotificationSetting(settingID); err != nil {
+			return err
+		}
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return errors.NewDatabaseError("Failed to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM streamer_notifications WHERE streamer_id = $1", streamerID); err != nil {
+		return errors.NewDatabaseError("Failed to clear streamer notifications", err)
+	}
+
+	for _, settingID := range settingIDs {
+		_, err := tx.Exec(`
+			INSERT INTO streamer_notifications (streamer_id, notification_setting_id)
+			VALUES ($1, $2)
+			ON CONFLICT DO NOTHING
+		`, streamerID, settingID)
+		if err != nil {
+			return errors.NewDatabaseError("Failed to add streamer notification", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.NewDatabaseError("Failed to commit transaction", err)
+	}
+
+	return nil
+}
+
+// RemoveStreamerNotification unsubscribes a streamer from a notification destination
+func (d *Database) RemoveStreamerNotification(streamerID, settingID int) error {
+	result, err := d.db.Exec(
+		"DELETE FROM streamer_notifications WHERE streamer_id = $1 AND notification_setting_id = $2",
+		streamerID, settingID,
+	)
+	if err != nil {
+		return errors.NewDatabaseError("Failed to remove streamer notification", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return errors.NewDatabaseError("Failed to get rows affected", err)
+	}
+
+	if rowsAffected == 0 {
+		return errors.NewNotFoundError("Streamer notification not found", nil)
+	}
+
+	return nil
+}
@@ -2,34 +2,118 @@ package discord
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
 	"time"
 
+	"github.com/drmaq/streamnotification/internal/errors"
 	"github.com/drmaq/streamnotification/internal/logger"
 	"github.com/drmaq/streamnotification/internal/models"
+	"github.com/drmaq/streamnotification/internal/notifier"
 )
 
-// Client represents a Discord webhook client
+// defaultTemplates are the embed titles used when a notification setting has no template
+var defaultTemplates = map[string]string{
+	models.EventTypeLive:           "{{.Streamer}} is now live on Twitch!",
+	models.EventTypeOffline:        "{{.Streamer}} was live on Twitch",
+	models.EventTypeTitleChange:    "{{.Streamer}} changed the stream title",
+	models.EventTypeCategoryChange: "{{.Streamer}} switched to {{.Game}}",
+	models.EventTypeMilestone:      "{{.Streamer}} {{if .NewRecord}}set a new viewer record of {{.Viewers}}{{else}}reached {{.Milestone}} viewers{{end}}!",
+}
+
+const (
+	discordAPIBaseURL = "https://discord.com/api/v10"
+
+	// maxTitleLength is the longest embed title Discord accepts
+	maxTitleLength = 256
+)
+
+// snowflakePattern matches Discord IDs, such as channel and role IDs
+var snowflakePattern = regexp.MustCompile(`^[0-9]{17,20}$`)
+
+// Client represents a Discord client, posting through webhooks or, with a bot token, the bot API
 type Client struct {
-	Logger *logger.Logger
+	Logger     *logger.Logger
+	botToken   string
+	apiBaseURL string
+	httpClient *http.Client
 }
 
-// NewClient creates a new Discord webhook client
-func NewClient(logger *logger.Logger) *Client {
+// NewClient creates a new Discord client. Without a bot token only webhook destinations can be used.
+func NewClient(logger *logger.Logger, botToken string) *Client {
 	return &Client{
-		Logger: logger,
+		Logger:     logger,
+		botToken:   botToken,
+		apiBaseURL: discordAPIBaseURL,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
 	}
 }
 
+// Capabilities describes the Discord notification channel
+func (c *Client) Capabilities() notifier.Capabilities {
+	return notifier.Capabilities{
+		Type:             models.NotificationTypeDiscord,
+		Name:             "Discord",
+		EventTypes:       models.EventTypes,
+		DestinationHint:  "Discord webhook URL, or a channel ID to post as the bot",
+		DefaultTemplates: defaultTemplates,
+	}
+}
+
+// ValidateDestination checks that the destination is a Discord webhook URL or channel ID,
+// and that the mention is one Discord understands
+func (c *Client) ValidateDestination(setting *models.NotificationSetting) error {
+	switch setting.Mention {
+	case "", "everyone", "here":
+	default:
+		if !snowflakePattern.MatchString(setting.Mention) {
+			return errors.NewValidationError("Discord mention must be everyone, here or a role ID", nil)
+		}
+	}
+
+	if isChannelID(setting.Destination) {
+		if c.botToken == "" {
+			return errors.NewValidationError("Posting to a Discord channel ID needs DISCORD_BOT_TOKEN", nil)
+		}
+		return nil
+	}
+
+	u, err := url.Parse(setting.Destination)
+	if err != nil || u.Scheme != "https" {
+		return errors.NewValidationError("Discord destination must be an https webhook URL", err)
+	}
+
+	switch u.Host {
+	case "discord.com", "discordapp.com", "canary.discord.com", "ptb.discord.com":
+	default:
+		return errors.NewValidationError("Discord destination must be a discord.com webhook URL", nil)
+	}
+
+	if !strings.HasPrefix(u.Path, "/api/webhooks/") {
+		return errors.NewValidationError("Discord destination must be a webhook URL", nil)
+	}
+
+	return nil
+}
+
+// CanEdit checks if the destination is a channel the bot posts to, whose messages it can edit
+func (c *Client) CanEdit(setting *models.NotificationSetting) bool {
+	return c.botToken != "" && isChannelID(setting.Destination)
+}
+
 // Embed represents a Discord embed message
 type Embed struct {
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	URL         string    `json:"url"`
-	Color       int       `json:"color"`
-	Timestamp   time.Time `json:"timestamp"`
+	Title       string     `json:"title"`
+	Description string     `json:"description,omitempty"`
+	URL         string     `json:"url"`
+	Color       int        `json:"color"`
+	Timestamp   *time.Time `json:"timestamp,omitempty"`
 	Thumbnail   struct {
 		URL string `json:"url"`
 	} `json:"thumbnail"`
@@ -40,21 +124,170 @@ type Embed struct {
 	} `json:"fields"`
 }
 
-// WebhookMessage represents a Discord webhook message
+// AllowedMentions limits who a message may ping
+type AllowedMentions struct {
+	Parse []string `json:"parse"`
+	Roles []string `json:"roles,omitempty"`
+}
+
+// WebhookMessage represents a Discord message, sent through a webhook or the bot API
 type WebhookMessage struct {
-	Content string  `json:"content"`
-	Embeds  []Embed `json:"embeds"`
+	Content         string           `json:"content"`
+	Embeds          []Embed          `json:"embeds"`
+	AllowedMentions *AllowedMentions `json:"allowed_mentions"`
+}
+
+// Send sends a notification to the Discord webhook or channel of a notification setting
+func (c *Client) Send(ctx context.Context, setting *models.NotificationSetting, event *models.StreamEvent) (*notifier.Receipt, error) {
+	// Render the embed title
+	title, err := notifier.RenderMessage(setting, event, defaultTemplates)
+	if err != nil {
+		return nil, err
+	}
+	if len([]rune(title)) > maxTitleLength {
+		title = string([]rune(title)[:maxTitleLength-1]) + "…"
+	}
+
+	var embed Embed
+	if event.EventType == models.EventTypeOffline {
+		embed = offlineEmbed(title, event)
+	} else {
+		embed = liveEmbed(title, event)
+	}
+
+	// Create message, pinging the configured mention when a stream goes live
+	msg := WebhookMessage{
+		Embeds:          []Embed{embed},
+		AllowedMentions: &AllowedMentions{Parse: []string{}},
+	}
+	if event.EventType == models.EventTypeLive {
+		msg.Content, msg.AllowedMentions = mention(setting.Mention)
+	}
+
+	var receipt *notifier.Receipt
+	if isChannelID(setting.Destination) {
+		receipt, err = c.do(ctx, "POST", fmt.Sprintf("%s/channels/%s/messages", c.apiBaseURL, setting.Destination), true, msg)
+	} else {
+		receipt, err = c.do(ctx, "POST", setting.Destination, false, msg)
+	}
+	if err != nil {
+		return receipt, err
+	}
+
+	c.Logger.Info("Sent Discord notification for %s", event.DisplayName)
+	return receipt, nil
+}
+
+// Edit turns the go-live message the bot posted to a channel into a summary of the ended stream
+func (c *Client) Edit(ctx context.Context, setting *models.NotificationSetting, messageID string, event *models.StreamEvent) (*notifier.Receipt, error) {
+	// Render the embed title
+	title, err := notifier.RenderMessage(setting, event, defaultTemplates)
+	if err != nil {
+		return nil, err
+	}
+	if len([]rune(title)) > maxTitleLength {
+		title = string([]rune(title)[:maxTitleLength-1]) + "…"
+	}
+
+	// An empty content removes the mention from the edited message
+	msg := WebhookMessage{
+		Embeds:          []Embed{editedEmbed(title, event)},
+		AllowedMentions: &AllowedMentions{Parse: []string{}},
+	}
+
+	receipt, err := c.do(ctx, "PATCH", fmt.Sprintf("%s/channels/%s/messages/%s", c.apiBaseURL, setting.Destination, messageID), true, msg)
+	if err != nil {
+		return receipt, err
+	}
+
+	c.Logger.Info("Edited Discord go-live message of %s", event.DisplayName)
+	return receipt, nil
+}
+
+// do sends a message request to a webhook URL or, authenticated as the bot, to the bot API,
+// and translates Discord errors into application errors
+func (c *Client) do(ctx context.Context, method, endpoint string, bot bool, msg WebhookMessage) (*notifier.Receipt, error) {
+	// Marshal message to JSON
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal Discord message: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewBuffer(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Discord request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if bot {
+		req.Header.Set("Authorization", "Bot "+c.botToken)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send Discord request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	receipt := &notifier.Receipt{StatusCode: resp.StatusCode}
+
+	// Check response status
+	if resp.StatusCode == http.StatusNoContent {
+		return receipt, nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode == http.StatusOK {
+		// The bot API returns the message, webhooks only do so when asked to wait
+		var message struct {
+			ID string `json:"id"`
+		}
+		if json.Unmarshal(body, &message) == nil {
+			receipt.MessageID = message.ID
+		}
+		return receipt, nil
+	}
+
+	reason := fmt.Errorf("Discord returned error status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return receipt, errors.NewRateLimitError("Discord is rate limited",
+			errors.ParseRetryAfter(resp.Header.Get("Retry-After")), reason)
+	case http.StatusNotFound:
+		// The webhook, channel or message was deleted
+		return receipt, errors.NewNotFoundError("Discord webhook, channel or message not found", reason)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return receipt, errors.NewUnauthorizedError("Discord bot is not allowed to post to the channel", reason)
+	case http.StatusBadRequest:
+		return receipt, errors.NewValidationError("Discord rejected the message", reason)
+	}
+	return receipt, reason
+}
+
+// mention returns the message content and allowed mentions that ping a mention setting
+func mention(value string) (string, *AllowedMentions) {
+	switch value {
+	case "":
+		return "", &AllowedMentions{Parse: []string{}}
+	case "everyone", "here":
+		return "@" + value, &AllowedMentions{Parse: []string{"everyone"}}
+	}
+	return fmt.Sprintf("<@&%s>", value), &AllowedMentions{Parse: []string{}, Roles: []string{value}}
+}
+
+// isChannelID checks if a destination is a channel ID rather than a webhook URL
+func isChannelID(destination string) bool {
+	return snowflakePattern.MatchString(destination)
 }
 
-// SendNotification sends a notification to a Discord webhook
-func (c *Client) SendNotification(webhookURL string, event *models.StreamEvent) error {
-	// Create embed message
+// liveEmbed builds the embed announcing that a streamer went live
+func liveEmbed(title string, event *models.StreamEvent) Embed {
 	embed := Embed{
-		Title:       fmt.Sprintf("%s is now live on Twitch!", event.DisplayName),
+		Title:       title,
 		Description: event.StreamTitle,
 		URL:         fmt.Sprintf("https://twitch.tv/%s", event.Username),
 		Color:       0x6441A4, // Twitch purple
-		Timestamp:   event.StartedAt,
+	}
+	if !event.StartedAt.IsZero() {
+		embed.Timestamp = &event.StartedAt
 	}
 
 	// Set thumbnail
@@ -68,7 +301,7 @@ func (c *Client) SendNotification(webhookURL string, event *models.StreamEvent)
 	}{
 		{
 			Name:   "Game",
-			Value:  event.GameName,
+			Value:  fieldValue(event.GameName),
 			Inline: true,
 		},
 		{
@@ -78,29 +311,63 @@ func (c *Client) SendNotification(webhookURL string, event *models.StreamEvent)
 		},
 	}
 
-	// Create webhook message
-	msg := WebhookMessage{
-		Embeds: []Embed{embed},
-	}
+	return embed
+}
 
-	// Marshal message to JSON
-	payload, err := json.Marshal(msg)
-	if err != nil {
-		return fmt.Errorf("failed to marshal Discord message: %w", err)
+// offlineEmbed builds the stream-ended summary embed
+func offlineEmbed(title string, event *models.StreamEvent) Embed {
+	embed := Embed{
+		Title:       title,
+		Description: fmt.Sprintf("Thanks for watching, stream lasted %s", models.FormatDuration(event.Duration())),
+		URL:         fmt.Sprintf("https://twitch.tv/%s", event.Username),
+		Color:       0x808080, // Grey
+	}
+	endedAt := time.Now()
+	if event.EndedAt != nil {
+		endedAt = *event.EndedAt
 	}
+	embed.Timestamp = &endedAt
 
-	// Send webhook request
-	resp, err := http.Post(webhookURL, "application/json", bytes.NewBuffer(payload))
-	if err != nil {
-		return fmt.Errorf("failed to send Discord webhook: %w", err)
+	// Add fields
+	embed.Fields = []struct {
+		Name   string `json:"name"`
+		Value  string `json:"value"`
+		Inline bool   `json:"inline"`
+	}{
+		{
+			Name:   "Title",
+			Value:  fieldValue(event.StreamTitle),
+			Inline: false,
+		},
+		{
+			Name:   "Game",
+			Value:  fieldValue(event.GameName),
+			Inline: true,
+		},
+		{
+			Name:   "Peak Viewers",
+			Value:  fmt.Sprintf("%d", event.PeakViewers),
+			Inline: true,
+		},
 	}
-	defer resp.Body.Close()
 
-	// Check response status
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
-		return fmt.Errorf("Discord webhook returned error status: %d", resp.StatusCode)
+	return embed
+}
+
+// fieldValue returns a placeholder for empty embed field values, which Discord rejects
+func fieldValue(value string) string {
+	if value == "" {
+		return "—"
 	}
+	return value
+}
 
-	c.Logger.Info("Sent Discord notification for %s", event.DisplayName)
-	return nil
-}
\ No newline at end of file
+// editedEmbed builds the embed that replaces a go-live message when the stream ends
+func editedEmbed(title string, event *models.StreamEvent) Embed {
+	embed := offlineEmbed(title, event)
+	embed.Description = fmt.Sprintf("Was live — %s, peak %d viewers", models.FormatDuration(event.Duration()), event.PeakViewers)
+
+	// Keep the stream title and game, the description covers the peak
+	embed.Fields = embed.Fields[:2]
+	return embed
+}
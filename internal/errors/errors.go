@@ -3,7 +3,9 @@ package errors
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/drmaq/streamnotification/internal/logger"
 )
@@ -26,6 +28,8 @@ const (
 	ErrorTypeNotFound ErrorType = "not_found"
 	// ErrorTypeUnauthorized represents an unauthorized error
 	ErrorTypeUnauthorized ErrorType = "unauthorized"
+	// ErrorTypeRateLimit represents a request rejected by a rate limit
+	ErrorTypeRateLimit ErrorType = "rate_limit"
 )
 
 // AppError represents an application error
@@ -34,6 +38,9 @@ type AppError struct {
 	Message string    `json:"message"`
 	Err     error     `json:"error,omitempty"`
 	Status  int       `json:"status,omitempty"`
+
+	// RetryAfter is how long to wait before retrying a rate limited request, if known
+	RetryAfter time.Duration `json:"-"`
 }
 
 // Error returns the error message
@@ -69,6 +76,8 @@ func (e *AppError) StatusCode() int {
 		return http.StatusNotFound
 	case ErrorTypeUnauthorized:
 		return http.StatusUnauthorized
+	case ErrorTypeRateLimit:
+		return http.StatusTooManyRequests
 	default:
 		return http.StatusInternalServerError
 	}
@@ -97,6 +106,8 @@ func LogError(log *logger.Logger, err error) {
 		log.Warn("Not found error: %v", appErr)
 	case ErrorTypeUnauthorized:
 		log.Warn("Unauthorized error: %v", appErr)
+	case ErrorTypeRateLimit:
+		log.Warn("Rate limit error: %v", appErr)
 	default:
 		log.Error("Unknown error: %v", appErr)
 	}
@@ -193,6 +204,18 @@ func NewUnauthorizedError(message string, err error) *AppError {
 	}
 }
 
+// NewRateLimitError creates a new rate limit error. retryAfter is the wait the
+// remote service asked for, or 0 if it did not say.
+func NewRateLimitError(message string, retryAfter time.Duration, err error) *AppError {
+	return &AppError{
+		Type:       ErrorTypeRateLimit,
+		Message:    message,
+		Err:        err,
+		Status:     http.StatusTooManyRequests,
+		RetryAfter: retryAfter,
+	}
+}
+
 // IsDatabaseError checks if the error is a database error
 func IsDatabaseError(err error) bool {
 	appErr, ok := err.(*AppError)
@@ -235,6 +258,36 @@ func IsUnauthorizedError(err error) bool {
 	return ok && appErr.Type == ErrorTypeUnauthorized
 }
 
+// IsRateLimitError checks if the error is a rate limit error
+func IsRateLimitError(err error) bool {
+	appErr, ok := err.(*AppError)
+	return ok && appErr.Type == ErrorTypeRateLimit
+}
+
+// RetryAfter returns how long a rate limit error asks to wait before retrying,
+// or 0 if the error is not a rate limit error or the wait is unknown
+func RetryAfter(err error) time.Duration {
+	appErr, ok := err.(*AppError)
+	if !ok || appErr.Type != ErrorTypeRateLimit {
+		return 0
+	}
+	return appErr.RetryAfter
+}
+
+// ParseRetryAfter parses an HTTP Retry-After header given in seconds or as a date,
+// returning 0 when it is missing or invalid
+func ParseRetryAfter(value string) time.Duration {
+	if seconds, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
 // IsErrorType checks if the error is of a specific type
 func IsErrorType(err error, errorType ErrorType) bool {
 	appErr, ok := err.(*AppError)
@@ -5,10 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/drmaq/streamnotification/internal/logger"
 	"github.com/drmaq/streamnotification/internal/models"
+	"github.com/drmaq/streamnotification/internal/notifier"
 )
 
 // APIClient handles communication with the backend API
@@ -116,6 +118,26 @@ func (c *APIClient) GetNotificationSettings() ([]models.NotificationSetting, err
 	return notifications, nil
 }
 
+// GetNotificationTypes fetches the available notification channels from the API
+func (c *APIClient) GetNotificationTypes() ([]notifier.Capabilities, error) {
+	resp, err := c.HTTPClient.Get(c.BaseURL + "/api/notifications/types")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get notification types: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API returned error: %s", resp.Status)
+	}
+
+	var types []notifier.Capabilities
+	if err := json.NewDecoder(resp.Body).Decode(&types); err != nil {
+		return nil, fmt.Errorf("failed to decode notification types: %w", err)
+	}
+
+	return types, nil
+}
+
 // AddNotificationSetting adds a new notification setting via the API
 func (c *APIClient) AddNotificationSetting(notification *models.NotificationSetting) error {
 	reqBody, err := json.Marshal(notification)
@@ -210,6 +232,52 @@ func (c *APIClient) GetLogs() ([]logger.LogEntry, error) {
 	return logs, nil
 }
 
+// GetDeliveries fetches notification delivery history from the API, passing query filters through
+func (c *APIClient) GetDeliveries(query url.Values) ([]models.Delivery, error) {
+	resp, err := c.HTTPClient.Get(c.BaseURL + "/api/deliveries?" + query.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deliveries: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API returned error: %s", resp.Status)
+	}
+
+	var deliveries []models.Delivery
+	if err := json.NewDecoder(resp.Body).Decode(&deliveries); err != nil {
+		return nil, fmt.Errorf("failed to decode deliveries: %w", err)
+	}
+
+	return deliveries, nil
+}
+
+// GetStats fetches stream stats for a streamer, or for the whole roster when
+// streamerID is 0, passing the period and time zone query parameters through
+func (c *APIClient) GetStats(streamerID int, query url.Values) (*models.StreamerStats, error) {
+	path := "/api/stats"
+	if streamerID != 0 {
+		path = fmt.Sprintf("/api/streamers/%d/stats", streamerID)
+	}
+
+	resp, err := c.HTTPClient.Get(c.BaseURL + path + "?" + query.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stats: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API returned error: %s", resp.Status)
+	}
+
+	var stats models.StreamerStats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, fmt.Errorf("failed to decode stats: %w", err)
+	}
+
+	return &stats, nil
+}
+
 // Login authenticates a user via the API
 func (c *APIClient) Login(username, password string) (*models.User, error) {
 	reqBody, err := json.Marshal(map[string]string{
@@ -2,6 +2,8 @@ package frontend
 
 import (
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/drmaq/streamnotification/internal/models"
@@ -69,9 +71,18 @@ func (r *Router) handleStreamers(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// Get notification settings from API for routing
+	notifications, err := r.API.GetNotificationSettings()
+	if err != nil {
+		r.Logger.Error("Failed to get notification settings: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
 	// Render template
 	data := map[string]interface{}{
-		"Streamers": streamers,
+		"Streamers":     streamers,
+		"Notifications": notifications,
 	}
 
 	r.templates.ExecuteTemplate(w, "streamers.html", data)
@@ -87,14 +98,114 @@ func (r *Router) handleNotifications(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// Get available notification channels from API
+	types, err := r.API.GetNotificationTypes()
+	if err != nil {
+		r.Logger.Error("Failed to get notification types: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
 	// Render template
 	data := map[string]interface{}{
-		"Notifications": notifications,
+		"Notifications":     notifications,
+		"NotificationTypes": types,
 	}
 
 	r.templates.ExecuteTemplate(w, "notifications.html", data)
 }
 
+// handleDeliveries handles the delivery history page
+func (r *Router) handleDeliveries(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+
+	// Get deliveries from API
+	deliveries, err := r.API.GetDeliveries(query)
+	if err != nil {
+		r.Logger.Error("Failed to get deliveries: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Get streamers and notification settings for the filters
+	streamers, err := r.API.GetStreamers()
+	if err != nil {
+		r.Logger.Error("Failed to get streamers: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	notifications, err := r.API.GetNotificationSettings()
+	if err != nil {
+		r.Logger.Error("Failed to get notification settings: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Render template
+	data := map[string]interface{}{
+		"Deliveries":    deliveries,
+		"Streamers":     streamers,
+		"Notifications": notifications,
+		"Filter": map[string]string{
+			"StreamerID":     query.Get("streamer_id"),
+			"NotificationID": query.Get("notification_id"),
+			"Status":         query.Get("status"),
+			"EventType":      query.Get("event_type"),
+			"From":           query.Get("from"),
+			"To":             query.Get("to"),
+		},
+	}
+
+	r.templates.ExecuteTemplate(w, "deliveries.html", data)
+}
+
+// handleAnalytics handles the stream analytics page
+func (r *Router) handleAnalytics(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+
+	// Only the period and time zone are passed to the API
+	statsQuery := url.Values{}
+	for _, key := range []string{"from", "to", "tz"} {
+		if v := query.Get(key); v != "" {
+			statsQuery.Set(key, v)
+		}
+	}
+
+	// Get stats for the whole roster from API
+	roster, err := r.API.GetStats(0, statsQuery)
+	if err != nil {
+		r.Logger.Error("Failed to get roster stats: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Get stats for the selected streamer, if any
+	stats := roster
+	if streamerID, err := strconv.Atoi(query.Get("streamer_id")); err == nil && streamerID > 0 {
+		stats, err = r.API.GetStats(streamerID, statsQuery)
+		if err != nil {
+			r.Logger.Error("Failed to get streamer stats: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Render template
+	data := map[string]interface{}{
+		"Stats":  stats,
+		"Roster": roster,
+		"Filter": map[string]string{
+			"StreamerID": query.Get("streamer_id"),
+			"From":       query.Get("from"),
+			"To":         query.Get("to"),
+			"TZ":         query.Get("tz"),
+		},
+	}
+
+	r.templates.ExecuteTemplate(w, "analytics.html", data)
+}
+
 // handleLogs handles the logs page
 func (r *Router) handleLogs(w http.ResponseWriter, req *http.Request) {
 	// Get logs from API
@@ -65,6 +65,8 @@ func (r *Router) setupRoutes() {
 	r.Router.HandleFunc("/", r.handleIndex).Methods("GET")
 	r.Router.HandleFunc("/streamers", r.handleStreamers).Methods("GET")
 	r.Router.HandleFunc("/notifications", r.handleNotifications).Methods("GET")
+	r.Router.HandleFunc("/deliveries", r.handleDeliveries).Methods("GET")
+	r.Router.HandleFunc("/analytics", r.handleAnalytics).Methods("GET")
 	r.Router.HandleFunc("/logs", r.handleLogs).Methods("GET")
 
 	// WebSocket route for live logs
@@ -0,0 +1,106 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// NotificationFilters are rules a stream event must pass to be sent to a destination.
+// Empty rules match every event.
+type NotificationFilters struct {
+	Games        []string `json:"games,omitempty"`         // Allowed game names or IDs
+	BlockedGames []string `json:"blocked_games,omitempty"` // Blocked game names or IDs
+	TitlePattern string   `json:"title_pattern,omitempty"` // Regular expression the title must match
+	MinViewers   int      `json:"min_viewers,omitempty"`
+	Languages    []string `json:"languages,omitempty"` // Allowed broadcaster languages, e.g. "en"
+}
+
+// IsEmpty reports whether the filters have no rules
+func (f *NotificationFilters) IsEmpty() bool {
+	return len(f.Games) == 0 && len(f.BlockedGames) == 0 && f.TitlePattern == "" &&
+		f.MinViewers == 0 && len(f.Languages) == 0
+}
+
+// Validate checks that the filter rules are well formed
+func (f *NotificationFilters) Validate() error {
+	if f.TitlePattern != "" {
+		if _, err := regexp.Compile(f.TitlePattern); err != nil {
+			return fmt.Errorf("invalid title pattern: %w", err)
+		}
+	}
+
+	if f.MinViewers < 0 {
+		return fmt.Errorf("minimum viewers cannot be negative")
+	}
+
+	for _, game := range append(f.Games, f.BlockedGames...) {
+		if strings.TrimSpace(game) == "" {
+			return fmt.Errorf("game names cannot be empty")
+		}
+	}
+
+	for _, language := range f.Languages {
+		if strings.TrimSpace(language) == "" {
+			return fmt.Errorf("languages cannot be empty")
+		}
+	}
+
+	return nil
+}
+
+// Matches checks if an event passes the filter rules. Rules about information the
+// event does not carry, such as the language of an offline event, are skipped.
+func (f *NotificationFilters) Matches(event *StreamEvent) bool {
+	if len(f.Games) > 0 && !matchesGame(f.Games, event) {
+		return false
+	}
+	if len(f.BlockedGames) > 0 && matchesGame(f.BlockedGames, event) {
+		return false
+	}
+
+	if f.TitlePattern != "" {
+		pattern, err := regexp.Compile(f.TitlePattern)
+		if err != nil || !pattern.MatchString(event.StreamTitle) {
+			return false
+		}
+	}
+
+	// Stream summaries are judged by their peak
+	viewers := event.ViewerCount
+	if event.PeakViewers > viewers {
+		viewers = event.PeakViewers
+	}
+	if viewers < f.MinViewers {
+		return false
+	}
+
+	if len(f.Languages) > 0 && event.Language != "" {
+		matched := false
+		for _, language := range f.Languages {
+			if strings.EqualFold(strings.TrimSpace(language), event.Language) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchesGame checks if an event's game is in a list of game names or IDs
+func matchesGame(games []string, event *StreamEvent) bool {
+	for _, game := range games {
+		game = strings.TrimSpace(game)
+		if event.GameID != "" && game == event.GameID {
+			return true
+		}
+		if strings.EqualFold(game, event.GameName) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package models
+
+import "testing"
+
+func TestNotificationFiltersMatches(t *testing.T) {
+	event := &StreamEvent{
+		StreamTitle: "Ranked grind with viewers",
+		GameName:    "VALORANT",
+		GameID:      "516575",
+		ViewerCount: 120,
+		Language:    "en",
+	}
+
+	tests := []struct {
+		name    string
+		filters NotificationFilters
+		event   *StreamEvent
+		want    bool
+	}{
+		{"empty filter", NotificationFilters{}, event, true},
+		{"included game", NotificationFilters{Games: []string{"Minecraft", "VALORANT"}}, event, true},
+		{"included game case folded", NotificationFilters{Games: []string{" valorant "}}, event, true},
+		{"included game ID", NotificationFilters{Games: []string{"516575"}}, event, true},
+		{"game not included", NotificationFilters{Games: []string{"Minecraft"}}, event, false},
+		{"excluded game", NotificationFilters{BlockedGames: []string{"Valorant"}}, event, false},
+		{"excluded game ID", NotificationFilters{BlockedGames: []string{"516575"}}, event, false},
+		{"other game excluded", NotificationFilters{BlockedGames: []string{"Minecraft"}}, event, true},
+		{"excluded wins over included", NotificationFilters{Games: []string{"VALORANT"}, BlockedGames: []string{"VALORANT"}}, event, false},
+		{"keyword", NotificationFilters{TitlePattern: "Ranked"}, event, true},
+		{"keyword is case sensitive", NotificationFilters{TitlePattern: "ranked"}, event, false},
+		{"keyword case folded", NotificationFilters{TitlePattern: "(?i)RANKED"}, event, true},
+		{"keyword missing", NotificationFilters{TitlePattern: "(?i)speedrun"}, event, false},
+		{"enough viewers", NotificationFilters{MinViewers: 120}, event, true},
+		{"too few viewers", NotificationFilters{MinViewers: 121}, event, false},
+		{"summary judged by peak", NotificationFilters{MinViewers: 150}, &StreamEvent{PeakViewers: 200}, true},
+		{"language case folded", NotificationFilters{Languages: []string{"EN"}}, event, true},
+		{"other language", NotificationFilters{Languages: []string{"de"}}, event, false},
+		{"language unknown", NotificationFilters{Languages: []string{"de"}}, &StreamEvent{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filters.Matches(tt.event); got != tt.want {
+				t.Fatalf("Matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNotificationFiltersValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		filters NotificationFilters
+		wantErr bool
+	}{
+		{"empty filter", NotificationFilters{}, false},
+		{"valid rules", NotificationFilters{Games: []string{"VALORANT"}, BlockedGames: []string{"516575"}, TitlePattern: "(?i)ranked", MinViewers: 10, Languages: []string{"en"}}, false},
+		{"invalid title pattern", NotificationFilters{TitlePattern: "(ranked"}, true},
+		{"negative viewers", NotificationFilters{MinViewers: -1}, true},
+		{"empty included game", NotificationFilters{Games: []string{" "}}, true},
+		{"empty excluded game", NotificationFilters{BlockedGames: []string{""}}, true},
+		{"empty language", NotificationFilters{Languages: []string{""}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.filters.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNotificationFiltersIsEmpty(t *testing.T) {
+	if !(&NotificationFilters{}).IsEmpty() {
+		t.Fatal("filter without rules is not empty")
+	}
+	if (&NotificationFilters{BlockedGames: []string{"VALORANT"}}).IsEmpty() {
+		t.Fatal("filter with an excluded game is empty")
+	}
+}
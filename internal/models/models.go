@@ -1,17 +1,60 @@
 package models
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
 // Streamer represents a Twitch streamer being monitored
 type Streamer struct {
-	ID                  int        `json:"id"`
-	Username            string     `json:"username"`
-	DisplayName         string     `json:"display_name"`
-	IsLive              bool       `json:"is_live"`
-	LastStreamStart     *time.Time `json:"last_stream_start"`
+	ID                   int        `json:"id"`
+	TwitchUserID         string     `json:"twitch_user_id"` // Immutable Twitch user ID, survives channel renames
+	Username             string     `json:"username"`
+	DisplayName          string     `json:"display_name"`
+	IsLive               bool       `json:"is_live"`
+	LastStreamStart      *time.Time `json:"last_stream_start"`
+	LastStreamEnd        *time.Time `json:"last_stream_end"`
 	LastNotificationSent *time.Time `json:"last_notification_sent"`
+	PeakViewers          int        `json:"peak_viewers"`
+	LastStreamTitle      string     `json:"last_stream_title"`
+	LastGameName         string     `json:"last_game_name"`
+
+	// Flap suppression
+	NotificationCooldownSeconds int        `json:"notification_cooldown_seconds"` // Minimum time between go-live notifications for the same stream
+	OfflineGraceSeconds         int        `json:"offline_grace_seconds"`         // How long a stream must stay down before it is treated as ended
+	LastStreamID                string     `json:"last_stream_id"`
+	OfflineSince                *time.Time `json:"offline_since"`
+	OfflineNotified             bool       `json:"offline_notified"` // The current stream continues one whose offline summary was queued
+
+	// Viewer milestones
+	MilestoneThresholds  []int64 `json:"milestone_thresholds"`    // Viewer counts announced once per stream when crossed
+	RecordViewers        int     `json:"record_viewers"`          // All-time peak viewer count
+	RecordViewersAtStart int     `json:"record_viewers_at_start"` // All-time peak when the current stream started
+}
+
+// NotificationCooldown returns the minimum time between go-live notifications
+func (s *Streamer) NotificationCooldown() time.Duration {
+	return time.Duration(s.NotificationCooldownSeconds) * time.Second
+}
+
+// OfflineGrace returns how long a stream must stay down before it is treated as ended
+func (s *Streamer) OfflineGrace() time.Duration {
+	return time.Duration(s.OfflineGraceSeconds) * time.Second
+}
+
+// CrossedMilestone returns the highest threshold a stream crossed when its peak rose
+// from previousPeak to viewers, or 0 if none was crossed
+func (s *Streamer) CrossedMilestone(previousPeak, viewers int) int {
+	crossed := 0
+	for _, threshold := range s.MilestoneThresholds {
+		t := int(threshold)
+		if t > 0 && previousPeak < t && viewers >= t && t > crossed {
+			crossed = t
+		}
+	}
+	return crossed
 }
 
 // NotificationType represents the type of notification
@@ -22,25 +65,224 @@ const (
 	NotificationTypeDiscord NotificationType = "discord"
 	// NotificationTypeTwitter represents a Twitter notification
 	NotificationTypeTwitter NotificationType = "twitter"
+	// NotificationTypeSlack represents a Slack incoming webhook notification
+	NotificationTypeSlack NotificationType = "slack"
+	// NotificationTypeTelegram represents a Telegram bot notification
+	NotificationTypeTelegram NotificationType = "telegram"
+	// NotificationTypeWebhook represents a signed outgoing webhook notification
+	NotificationTypeWebhook NotificationType = "webhook"
+)
+
+const (
+	// EventTypeLive is sent when a streamer goes live
+	EventTypeLive = "live"
+	// EventTypeOffline is sent when a streamer's stream ends
+	EventTypeOffline = "offline"
+	// EventTypeTitleChange is sent when a live streamer changes their stream title
+	EventTypeTitleChange = "title_change"
+	// EventTypeCategoryChange is sent when a live streamer switches game or category
+	EventTypeCategoryChange = "category_change"
+	// EventTypeMilestone is sent when a live stream crosses a viewer milestone or the streamer's all-time peak
+	EventTypeMilestone = "milestone"
 )
 
+// EventTypes lists every event type a destination can opt into
+var EventTypes = []string{EventTypeLive, EventTypeOffline, EventTypeTitleChange, EventTypeCategoryChange, EventTypeMilestone}
+
+// IsValidEventType checks if an event type is known
+func IsValidEventType(eventType string) bool {
+	for _, t := range EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
 // NotificationSetting represents a notification destination
 type NotificationSetting struct {
-	ID          int              `json:"id"`
-	Type        NotificationType `json:"type"`
-	Destination string           `json:"destination"` // Discord channel ID or Twitter account
-	Enabled     bool             `json:"enabled"`
+	ID           int               `json:"id"`
+	Type         NotificationType  `json:"type"`
+	Destination  string            `json:"destination"` // Discord channel ID or Twitter account
+	Enabled      bool              `json:"enabled"`
+	EventTypes   []string          `json:"event_types"`   // Event types sent to this destination
+	AllStreamers bool              `json:"all_streamers"` // Notify for every streamer instead of subscribed ones
+	Templates    map[string]string `json:"templates"`     // Message templates keyed by event type
+
+	// Filters are rules an event must pass to be sent to this destination
+	Filters NotificationFilters `json:"filters"`
+
+	// Mention is pinged by Discord go-live posts: "everyone", "here" or a role ID
+	Mention string `json:"mention,omitempty"`
+
+	// Headers and Secret configure outgoing webhook destinations
+	Headers map[string]string `json:"headers,omitempty"` // Custom request headers
+	Secret  string            `json:"secret,omitempty"`  // Key of the HMAC-SHA256 request signature
+}
+
+// WantsEvent checks if the destination opted into an event type.
+// Destinations without event types only receive go-live notifications.
+func (n *NotificationSetting) WantsEvent(eventType string) bool {
+	if len(n.EventTypes) == 0 {
+		return eventType == EventTypeLive
+	}
+
+	for _, t := range n.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Template returns the custom message template for an event type, or "" to use the channel default
+func (n *NotificationSetting) Template(eventType string) string {
+	return n.Templates[eventType]
 }
 
-// StreamEvent represents a stream event (going live or offline)
+// StreamEvent represents a stream event (going live or offline, or a change while live)
 type StreamEvent struct {
-	StreamerID   int       `json:"streamer_id"`
-	Username     string    `json:"username"`
-	DisplayName  string    `json:"display_name"`
-	EventType    string    `json:"event_type"` // "live" or "offline"
-	StreamTitle  string    `json:"stream_title"`
-	GameName     string    `json:"game_name"`
-	ThumbnailURL string    `json:"thumbnail_url"`
-	ViewerCount  int       `json:"viewer_count"`
-	StartedAt    time.Time `json:"started_at"`
-}
\ No newline at end of file
+	StreamerID    int        `json:"streamer_id"`
+	StreamID      string     `json:"stream_id,omitempty"` // Twitch stream ID
+	Username      string     `json:"username"`
+	DisplayName   string     `json:"display_name"`
+	EventType     string     `json:"event_type"` // One of EventTypes
+	StreamTitle   string     `json:"stream_title"`
+	GameName      string     `json:"game_name"`
+	GameID        string     `json:"game_id,omitempty"`
+	Language      string     `json:"language,omitempty"`       // Broadcaster language, e.g. "en"
+	PreviousTitle string     `json:"previous_title,omitempty"` // Title before a title_change
+	PreviousGame  string     `json:"previous_game,omitempty"`  // Game before a category_change
+	Milestone     int        `json:"milestone,omitempty"`      // Viewer threshold crossed by a milestone
+	NewRecord     bool       `json:"new_record,omitempty"`     // Milestone beat the all-time peak
+	PreviousPeak  int        `json:"previous_peak,omitempty"`  // All-time peak beaten by a new record
+	ThumbnailURL  string     `json:"thumbnail_url"`
+	ViewerCount   int        `json:"viewer_count"`
+	PeakViewers   int        `json:"peak_viewers,omitempty"`
+	StartedAt     time.Time  `json:"started_at"`
+	EndedAt       *time.Time `json:"ended_at,omitempty"`
+}
+
+// Duration returns how long the stream lasted, or has lasted so far
+func (e *StreamEvent) Duration() time.Duration {
+	if e.StartedAt.IsZero() {
+		return 0
+	}
+
+	end := time.Now()
+	if e.EndedAt != nil {
+		end = *e.EndedAt
+	}
+	return end.Sub(e.StartedAt)
+}
+
+// Thumbnail returns the stream thumbnail URL at the given size. Twitch thumbnail
+// URLs contain {width} and {height} placeholders.
+func (e *StreamEvent) Thumbnail(width, height int) string {
+	return strings.NewReplacer(
+		"{width}", strconv.Itoa(width),
+		"{height}", strconv.Itoa(height),
+	).Replace(e.ThumbnailURL)
+}
+
+// FormatDuration formats a duration as hours and minutes, e.g. "3h12m"
+func FormatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	hours := int(d / time.Hour)
+	minutes := int((d % time.Hour) / time.Minute)
+
+	if hours == 0 {
+		return fmt.Sprintf("%dm", minutes)
+	}
+	return fmt.Sprintf("%dh%02dm", hours, minutes)
+}
+
+// StreamSession represents a single broadcast of a streamer
+type StreamSession struct {
+	ID             int             `json:"id"`
+	StreamerID     int             `json:"streamer_id"`
+	StreamID       string          `json:"stream_id"`
+	StartedAt      time.Time       `json:"started_at"`
+	EndedAt        *time.Time      `json:"ended_at"`
+	Title          string          `json:"title"`
+	GameName       string          `json:"game_name"`
+	ThumbnailURL   string          `json:"thumbnail_url"`
+	PeakViewers    int             `json:"peak_viewers"`
+	AverageViewers int             `json:"average_viewers"`
+	Changes        []SessionChange `json:"changes"`
+}
+
+// SessionChange records a title or game change during a stream session
+type SessionChange struct {
+	Title     string    `json:"title"`
+	GameName  string    `json:"game_name"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// StreamSample records a stream session's viewer count, title and game at one poll
+type StreamSample struct {
+	SampledAt     time.Time `json:"sampled_at"`
+	ViewerCount   int       `json:"viewer_count"`
+	Title         string    `json:"title"`
+	GameName      string    `json:"game_name"`
+	BucketSeconds int       `json:"bucket_seconds,omitempty"` // Set when the sample averages a downsampled bucket
+}
+
+// OutboxStatus represents the delivery state of an outbox entry
+type OutboxStatus string
+
+const (
+	// OutboxStatusPending is waiting for (another) delivery attempt
+	OutboxStatusPending OutboxStatus = "pending"
+	// OutboxStatusSent was delivered successfully
+	OutboxStatusSent OutboxStatus = "sent"
+	// OutboxStatusDead exhausted its attempts and needs a manual replay
+	OutboxStatusDead OutboxStatus = "dead"
+)
+
+// OutboxEntry represents a queued delivery of an event to one destination
+type OutboxEntry struct {
+	ID                    int          `json:"id"`
+	NotificationSettingID int          `json:"notification_setting_id"`
+	StreamerID            int          `json:"streamer_id"`
+	EventType             string       `json:"event_type"`
+	Event                 *StreamEvent `json:"event"`
+	Status                OutboxStatus `json:"status"`
+	Attempts              int          `json:"attempts"`
+	NextAttemptAt         time.Time    `json:"next_attempt_at"`
+	LastError             string       `json:"last_error"`
+	CreatedAt             time.Time    `json:"created_at"`
+	UpdatedAt             time.Time    `json:"updated_at"`
+	SentAt                *time.Time   `json:"sent_at"`
+}
+
+// DeliveryStatus represents the outcome of a delivery attempt
+type DeliveryStatus string
+
+const (
+	// DeliveryStatusSent was delivered successfully
+	DeliveryStatusSent DeliveryStatus = "sent"
+	// DeliveryStatusFailed failed and will be retried
+	DeliveryStatusFailed DeliveryStatus = "failed"
+	// DeliveryStatusDead failed and was moved to the dead letters
+	DeliveryStatusDead DeliveryStatus = "dead"
+)
+
+// Delivery records a single attempt to deliver a notification
+type Delivery struct {
+	ID                    int              `json:"id"`
+	OutboxID              int              `json:"outbox_id"`
+	StreamerID            int              `json:"streamer_id"`
+	StreamerName          string           `json:"streamer_name"`
+	NotificationSettingID int              `json:"notification_setting_id"`
+	NotificationType      NotificationType `json:"notification_type"`
+	Destination           string           `json:"destination"`
+	EventType             string           `json:"event_type"`
+	Attempt               int              `json:"attempt"`
+	Status                DeliveryStatus   `json:"status"`
+	StatusCode            int              `json:"status_code"`
+	Error                 string           `json:"error"`
+	LatencyMS             int64            `json:"latency_ms"`
+	MessageID             string           `json:"message_id"`
+	CreatedAt             time.Time        `json:"created_at"`
+}
@@ -0,0 +1,160 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// maxTopCategories is the number of categories listed in streamer stats
+const maxTopCategories = 5
+
+// StreamerStats summarizes the streams of a streamer, or of every streamer, over a period
+type StreamerStats struct {
+	StreamerID     int             `json:"streamer_id,omitempty"` // 0 for the whole roster
+	DisplayName    string          `json:"display_name,omitempty"`
+	From           time.Time       `json:"from"`
+	To             time.Time       `json:"to"`
+	Streams        int             `json:"streams"`
+	HoursStreamed  float64         `json:"hours_streamed"`
+	StreamsPerWeek float64         `json:"streams_per_week"`
+	AverageViewers int             `json:"average_viewers"` // Weighted by stream length
+	PeakViewers    int             `json:"peak_viewers"`
+	TopCategories  []CategoryStats `json:"top_categories"`
+	GoLiveTimes    []WeekdayStats  `json:"go_live_times"`       // Monday first
+	Streamers      []StreamerStats `json:"streamers,omitempty"` // Per-streamer breakdown of roster stats
+}
+
+// CategoryStats is the time spent in one game or category
+type CategoryStats struct {
+	GameName string  `json:"game_name"`
+	Streams  int     `json:"streams"`
+	Hours    float64 `json:"hours"`
+}
+
+// WeekdayStats describes when streams started on one day of the week
+type WeekdayStats struct {
+	Weekday      string `json:"weekday"`
+	Streams      int    `json:"streams"`
+	TypicalStart string `json:"typical_start,omitempty"` // Median start time, e.g. "18:30"
+}
+
+// NewStreamerStats computes stats for the sessions that started between from and to.
+// categoryTime holds the time spent in each game by session ID, taken from the stream
+// timeline; sessions without a timeline count entirely towards their last game.
+// Go-live times are reported in loc.
+func NewStreamerStats(sessions []StreamSession, categoryTime map[int]map[string]time.Duration, from, to time.Time, loc *time.Location) *StreamerStats {
+	stats := &StreamerStats{
+		From:          from,
+		To:            to,
+		Streams:       len(sessions),
+		TopCategories: []CategoryStats{},
+	}
+
+	now := time.Now()
+	var total, viewerTime time.Duration
+	var weightedViewers float64
+	categories := make(map[string]*CategoryStats)
+	starts := make([][]int, 7) // Minutes after midnight by weekday, Monday first
+
+	for _, session := range sessions {
+		end := now
+		if session.EndedAt != nil {
+			end = *session.EndedAt
+		}
+		duration := end.Sub(session.StartedAt)
+		if duration < 0 {
+			duration = 0
+		}
+		total += duration
+
+		// Average viewers over the streams that reported any
+		if session.AverageViewers > 0 {
+			weightedViewers += float64(session.AverageViewers) * duration.Hours()
+			viewerTime += duration
+		}
+		if session.PeakViewers > stats.PeakViewers {
+			stats.PeakViewers = session.PeakViewers
+		}
+
+		// Split the stream's length between its games
+		for game, share := range gameShares(session, categoryTime[session.ID]) {
+			category := categories[game]
+			if category == nil {
+				category = &CategoryStats{GameName: game}
+				categories[game] = category
+			}
+			category.Streams++
+			category.Hours += duration.Hours() * share
+		}
+
+		start := session.StartedAt.In(loc)
+		weekday := (int(start.Weekday()) + 6) % 7
+		starts[weekday] = append(starts[weekday], start.Hour()*60+start.Minute())
+	}
+
+	stats.HoursStreamed = round(total.Hours())
+	if weeks := to.Sub(from).Hours() / (24 * 7); weeks > 0 {
+		stats.StreamsPerWeek = round(float64(len(sessions)) / weeks)
+	}
+	if viewerTime > 0 {
+		stats.AverageViewers = int(weightedViewers/viewerTime.Hours() + 0.5)
+	}
+
+	// Most played categories first
+	for _, category := range categories {
+		category.Hours = round(category.Hours)
+		stats.TopCategories = append(stats.TopCategories, *category)
+	}
+	sort.Slice(stats.TopCategories, func(i, j int) bool {
+		a, b := stats.TopCategories[i], stats.TopCategories[j]
+		if a.Hours != b.Hours {
+			return a.Hours > b.Hours
+		}
+		return a.GameName < b.GameName
+	})
+	if len(stats.TopCategories) > maxTopCategories {
+		stats.TopCategories = stats.TopCategories[:maxTopCategories]
+	}
+
+	for i, minutes := range starts {
+		day := WeekdayStats{
+			Weekday: time.Weekday((i + 1) % 7).String(),
+			Streams: len(minutes),
+		}
+		if len(minutes) > 0 {
+			sort.Ints(minutes)
+			median := minutes[len(minutes)/2]
+			day.TypicalStart = fmt.Sprintf("%02d:%02d", median/60, median%60)
+		}
+		stats.GoLiveTimes = append(stats.GoLiveTimes, day)
+	}
+
+	return stats
+}
+
+// gameShares returns the fraction of a session spent in each game
+func gameShares(session StreamSession, times map[string]time.Duration) map[string]float64 {
+	var total time.Duration
+	for _, d := range times {
+		total += d
+	}
+
+	if total == 0 {
+		if session.GameName == "" {
+			return nil
+		}
+		return map[string]float64{session.GameName: 1}
+	}
+
+	shares := make(map[string]float64, len(times))
+	for game, d := range times {
+		shares[game] = float64(d) / float64(total)
+	}
+	return shares
+}
+
+// round rounds to one decimal place
+func round(v float64) float64 {
+	return float64(int64(v*10+0.5)) / 10
+}
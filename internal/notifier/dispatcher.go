@@ -0,0 +1,230 @@
+package notifier
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/drmaq/streamnotification/internal/db"
+	"github.com/drmaq/streamnotification/internal/errors"
+	"github.com/drmaq/streamnotification/internal/logger"
+	"github.com/drmaq/streamnotification/internal/models"
+)
+
+const (
+	// dispatchInterval is how often the outbox is checked for due deliveries
+	dispatchInterval = 5 * time.Second
+
+	// dispatchBatchSize is the maximum number of deliveries claimed at once
+	dispatchBatchSize = 50
+
+	// dispatchLease is how long a claimed delivery is hidden from other dispatchers
+	dispatchLease = 2 * time.Minute
+
+	// sendTimeout bounds a single delivery attempt
+	sendTimeout = 30 * time.Second
+)
+
+// outboxStore is the part of the database the dispatcher works with
+type outboxStore interface {
+	ClaimDueOutboxEntries(limit int, lease time.Duration) ([]models.OutboxEntry, error)
+	MarkOutboxSent(id, attempts int) error
+	MarkOutboxRetry(id, attempts int, delay time.Duration, lastError string) error
+	MarkOutboxDead(id, attempts int, lastError string) error
+	RecordDelivery(delivery *models.Delivery) error
+	GetNotificationSetting(id int) (*models.NotificationSetting, error)
+	GetStreamMessageID(settingID int, streamID string) (string, error)
+	SaveStreamMessage(settingID, streamerID int, streamID, messageID string) error
+}
+
+// Dispatcher delivers queued notifications from the outbox, retrying failed
+// deliveries with exponential backoff until they are dead-lettered
+type Dispatcher struct {
+	db          outboxStore
+	registry    *Registry
+	logger      *logger.Logger
+	MaxAttempts int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+}
+
+// NewDispatcher creates a new outbox dispatcher
+func NewDispatcher(database *db.Database, registry *Registry, logger *logger.Logger, maxAttempts int) *Dispatcher {
+	return &Dispatcher{
+		db:          database,
+		registry:    registry,
+		logger:      logger,
+		MaxAttempts: maxAttempts,
+		BaseBackoff: 30 * time.Second,
+		MaxBackoff:  time.Hour,
+	}
+}
+
+// Run delivers due notifications until the context is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	d.logger.Info("Starting notification dispatcher")
+
+	ticker := time.NewTicker(dispatchInterval)
+	defer ticker.Stop()
+
+	for {
+		d.dispatchDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			d.logger.Info("Stopping notification dispatcher")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatchDue claims and delivers every due outbox entry
+func (d *Dispatcher) dispatchDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		entries, err := d.db.ClaimDueOutboxEntries(dispatchBatchSize, dispatchLease)
+		if err != nil {
+			d.logger.Error("Failed to claim outbox entries: %v", err)
+			return
+		}
+
+		for i := range entries {
+			d.deliver(ctx, &entries[i])
+		}
+
+		if len(entries) < dispatchBatchSize {
+			return
+		}
+	}
+}
+
+// deliver makes one delivery attempt and records its outcome
+func (d *Dispatcher) deliver(ctx context.Context, entry *models.OutboxEntry) {
+	attempt := entry.Attempts + 1
+
+	// Every attempt is kept in the delivery history
+	delivery := &models.Delivery{
+		OutboxID:              entry.ID,
+		StreamerID:            entry.StreamerID,
+		StreamerName:          entry.Event.DisplayName,
+		NotificationSettingID: entry.NotificationSettingID,
+		EventType:             entry.EventType,
+		Attempt:               attempt,
+	}
+	defer d.recordDelivery(delivery)
+
+	setting, err := d.db.GetNotificationSetting(entry.NotificationSettingID)
+	if err != nil {
+		delivery.Error = err.Error()
+		delivery.Status = d.fail(entry, attempt, err)
+		return
+	}
+	delivery.NotificationType = setting.Type
+	delivery.Destination = setting.Destination
+
+	start := time.Now()
+	sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+	receipt, err := d.send(sendCtx, setting, entry)
+	cancel()
+	delivery.LatencyMS = time.Since(start).Milliseconds()
+
+	if receipt != nil {
+		delivery.StatusCode = receipt.StatusCode
+		delivery.MessageID = receipt.MessageID
+	}
+
+	if err != nil {
+		delivery.Error = err.Error()
+		delivery.Status = d.fail(entry, attempt, err)
+		return
+	}
+	delivery.Status = models.DeliveryStatusSent
+
+	if err := d.db.MarkOutboxSent(entry.ID, attempt); err != nil {
+		d.logger.Error("Failed to record delivery %d: %v", entry.ID, err)
+		return
+	}
+
+	d.logger.Info("Delivered %s notification for %s to %s", entry.EventType, entry.Event.DisplayName, setting.Type)
+}
+
+// send delivers an entry's event. Where the channel can edit its messages, the go-live
+// message of a stream is recorded and edited into the summary when the stream ends.
+func (d *Dispatcher) send(ctx context.Context, setting *models.NotificationSetting, entry *models.OutboxEntry) (*Receipt, error) {
+	event := entry.Event
+	editor, canEdit := d.registry.Editor(setting)
+	if !canEdit || event.StreamID == "" {
+		return d.registry.Send(ctx, setting, event)
+	}
+
+	if event.EventType == models.EventTypeOffline {
+		messageID, err := d.db.GetStreamMessageID(setting.ID, event.StreamID)
+		if err != nil {
+			return nil, err
+		}
+		if messageID != "" {
+			receipt, err := editor.Edit(ctx, setting, messageID, event)
+			if !errors.IsNotFoundError(err) {
+				return receipt, err
+			}
+
+			// The go-live message was deleted, post the summary instead
+			d.logger.Warn("Go-live message %s of %s is gone, sending a new summary", messageID, event.DisplayName)
+		}
+	}
+
+	receipt, err := d.registry.Send(ctx, setting, event)
+	if err == nil && event.EventType == models.EventTypeLive && receipt != nil && receipt.MessageID != "" {
+		if dbErr := d.db.SaveStreamMessage(setting.ID, entry.StreamerID, event.StreamID, receipt.MessageID); dbErr != nil {
+			d.logger.Error("Failed to record go-live message of delivery %d: %v", entry.ID, dbErr)
+		}
+	}
+	return receipt, err
+}
+
+// recordDelivery saves a delivery attempt to the history
+func (d *Dispatcher) recordDelivery(delivery *models.Delivery) {
+	if err := d.db.RecordDelivery(delivery); err != nil {
+		d.logger.Error("Failed to record delivery history for %d: %v", delivery.OutboxID, err)
+	}
+}
+
+// fail schedules a retry or dead-letters the entry once it runs out of attempts,
+// and returns the resulting delivery status
+func (d *Dispatcher) fail(entry *models.OutboxEntry, attempt int, err error) models.DeliveryStatus {
+	// Validation and not-found errors will not succeed on retry
+	permanent := errors.IsValidationError(err) || errors.IsNotFoundError(err)
+
+	if permanent || attempt >= d.MaxAttempts {
+		d.logger.Error("Dead-lettered %s notification %d after %d attempts: %v", entry.EventType, entry.ID, attempt, err)
+		if dbErr := d.db.MarkOutboxDead(entry.ID, attempt, err.Error()); dbErr != nil {
+			d.logger.Error("Failed to dead-letter delivery %d: %v", entry.ID, dbErr)
+		}
+		return models.DeliveryStatusDead
+	}
+
+	// Wait as long as a rate limited channel asked, instead of backing off blindly
+	delay := d.backoff(attempt)
+	if retryAfter := errors.RetryAfter(err); retryAfter > 0 {
+		delay = retryAfter
+	}
+	d.logger.Warn("Delivery %d failed (attempt %d/%d), retrying in %v: %v", entry.ID, attempt, d.MaxAttempts, delay, err)
+	if dbErr := d.db.MarkOutboxRetry(entry.ID, attempt, delay, err.Error()); dbErr != nil {
+		d.logger.Error("Failed to reschedule delivery %d: %v", entry.ID, dbErr)
+	}
+	return models.DeliveryStatusFailed
+}
+
+// backoff returns the delay before the next attempt, doubling each time with up to 20% jitter
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	delay := d.BaseBackoff
+	for i := 1; i < attempt && delay < d.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.MaxBackoff {
+		delay = d.MaxBackoff
+	}
+
+	jitter := time.Duration(rand.Int63n(int64(delay)/5 + 1))
+	return delay + jitter
+}
@@ -0,0 +1,342 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drmaq/streamnotification/internal/errors"
+	"github.com/drmaq/streamnotification/internal/logger"
+	"github.com/drmaq/streamnotification/internal/models"
+)
+
+// fakeStore is an in-memory outbox that claims, leases and reschedules entries
+// the way the notification_outbox queries do, against a clock set by the test
+type fakeStore struct {
+	mu         sync.Mutex
+	now        time.Time
+	entries    map[int]*models.OutboxEntry
+	deliveries []models.Delivery
+	leases     []time.Duration // Lease requested by each claim
+}
+
+// newFakeStore creates an outbox holding count pending entries for one webhook destination
+func newFakeStore(count int) *fakeStore {
+	s := &fakeStore{
+		now:     time.Date(2024, 5, 1, 18, 0, 0, 0, time.UTC),
+		entries: make(map[int]*models.OutboxEntry),
+	}
+	for id := 1; id <= count; id++ {
+		s.entries[id] = &models.OutboxEntry{
+			ID:                    id,
+			NotificationSettingID: 1,
+			StreamerID:            1,
+			EventType:             models.EventTypeLive,
+			Event:                 &models.StreamEvent{StreamerID: 1, DisplayName: "shroud", EventType: models.EventTypeLive},
+			Status:                models.OutboxStatusPending,
+			NextAttemptAt:         s.now,
+		}
+	}
+	return s
+}
+
+// advance moves the store clock forward
+func (s *fakeStore) advance(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.now = s.now.Add(d)
+}
+
+// entry returns a copy of an outbox entry
+func (s *fakeStore) entry(id int) models.OutboxEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.entries[id]
+}
+
+// history returns the recorded delivery attempts
+func (s *fakeStore) history() []models.Delivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]models.Delivery(nil), s.deliveries...)
+}
+
+func (s *fakeStore) ClaimDueOutboxEntries(limit int, lease time.Duration) ([]models.OutboxEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.leases = append(s.leases, lease)
+
+	var due []*models.OutboxEntry
+	for _, e := range s.entries {
+		if e.Status == models.OutboxStatusPending && !e.NextAttemptAt.After(s.now) {
+			due = append(due, e)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if due[i].NextAttemptAt.Equal(due[j].NextAttemptAt) {
+			return due[i].ID < due[j].ID
+		}
+		return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	claimed := make([]models.OutboxEntry, len(due))
+	for i, e := range due {
+		e.NextAttemptAt = s.now.Add(lease)
+		claimed[i] = *e
+	}
+	return claimed, nil
+}
+
+func (s *fakeStore) MarkOutboxSent(id, attempts int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.entries[id]
+	e.Status = models.OutboxStatusSent
+	e.Attempts = attempts
+	e.LastError = ""
+	return nil
+}
+
+func (s *fakeStore) MarkOutboxRetry(id, attempts int, delay time.Duration, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.entries[id]
+	e.Attempts = attempts
+	e.NextAttemptAt = s.now.Add(delay)
+	e.LastError = lastError
+	return nil
+}
+
+func (s *fakeStore) MarkOutboxDead(id, attempts int, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.entries[id]
+	e.Status = models.OutboxStatusDead
+	e.Attempts = attempts
+	e.LastError = lastError
+	return nil
+}
+
+func (s *fakeStore) RecordDelivery(delivery *models.Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deliveries = append(s.deliveries, *delivery)
+	return nil
+}
+
+func (s *fakeStore) GetNotificationSetting(id int) (*models.NotificationSetting, error) {
+	if id != 1 {
+		return nil, errors.NewNotFoundError("Notification setting not found", nil)
+	}
+	return &models.NotificationSetting{ID: 1, Type: models.NotificationTypeWebhook, Destination: "https://example.com/hook"}, nil
+}
+
+func (s *fakeStore) GetStreamMessageID(settingID int, streamID string) (string, error) {
+	return "", nil
+}
+
+func (s *fakeStore) SaveStreamMessage(settingID, streamerID int, streamID, messageID string) error {
+	return nil
+}
+
+// fakeNotifier returns the errors it is given, in order, then succeeds
+type fakeNotifier struct {
+	mu    sync.Mutex
+	errs  []error
+	sends int
+}
+
+func (n *fakeNotifier) Send(ctx context.Context, setting *models.NotificationSetting, event *models.StreamEvent) (*Receipt, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sends++
+	if len(n.errs) == 0 {
+		return &Receipt{StatusCode: 204}, nil
+	}
+	err := n.errs[0]
+	n.errs = n.errs[1:]
+	return nil, err
+}
+
+func (n *fakeNotifier) ValidateDestination(setting *models.NotificationSetting) error {
+	return nil
+}
+
+func (n *fakeNotifier) Capabilities() Capabilities {
+	return Capabilities{Type: models.NotificationTypeWebhook, EventTypes: models.EventTypes}
+}
+
+// newTestDispatcher creates a dispatcher delivering from store through n
+func newTestDispatcher(store *fakeStore, n *fakeNotifier, maxAttempts int) *Dispatcher {
+	registry := NewRegistry()
+	registry.Register(n)
+
+	d := NewDispatcher(nil, registry, logger.NewLogger(), maxAttempts)
+	d.db = store
+	return d
+}
+
+func TestDispatcherDelivers(t *testing.T) {
+	store := newFakeStore(1)
+	d := newTestDispatcher(store, &fakeNotifier{}, 3)
+
+	d.dispatchDue(context.Background())
+
+	if e := store.entry(1); e.Status != models.OutboxStatusSent || e.Attempts != 1 {
+		t.Fatalf("entry is %s after %d attempts, want sent after 1", e.Status, e.Attempts)
+	}
+	history := store.history()
+	if len(history) != 1 || history[0].Status != models.DeliveryStatusSent || history[0].StatusCode != 204 {
+		t.Fatalf("recorded %+v, want one sent delivery with status 204", history)
+	}
+}
+
+func TestDispatcherFailures(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus models.OutboxStatus
+		wantDelay  time.Duration // Exact delay before the retry, or zero for the backoff
+	}{
+		{"retryable error", errors.NewAPIError("Webhook returned status 503", nil), models.OutboxStatusPending, 0},
+		{"plain error", fmt.Errorf("connection reset"), models.OutboxStatusPending, 0},
+		{"rate limited", errors.NewRateLimitError("Webhook rate limited", 90*time.Second, nil), models.OutboxStatusPending, 90 * time.Second},
+		{"validation error", errors.NewValidationError("Webhook returned status 400", nil), models.OutboxStatusDead, 0},
+		{"not found error", errors.NewNotFoundError("Webhook returned status 410", nil), models.OutboxStatusDead, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore(1)
+			d := newTestDispatcher(store, &fakeNotifier{errs: []error{tt.err}}, 5)
+			start := store.now
+
+			d.dispatchDue(context.Background())
+
+			e := store.entry(1)
+			if e.Status != tt.wantStatus || e.Attempts != 1 {
+				t.Fatalf("entry is %s after %d attempts, want %s after 1", e.Status, e.Attempts, tt.wantStatus)
+			}
+			if e.LastError != tt.err.Error() {
+				t.Fatalf("last error %q, want %q", e.LastError, tt.err.Error())
+			}
+
+			history := store.history()
+			wantDelivery := models.DeliveryStatusFailed
+			if tt.wantStatus == models.OutboxStatusDead {
+				wantDelivery = models.DeliveryStatusDead
+			}
+			if len(history) != 1 || history[0].Status != wantDelivery {
+				t.Fatalf("recorded %+v, want one %s delivery", history, wantDelivery)
+			}
+
+			if tt.wantStatus != models.OutboxStatusPending {
+				return
+			}
+			delay := e.NextAttemptAt.Sub(start)
+			if tt.wantDelay > 0 && delay != tt.wantDelay {
+				t.Fatalf("retry in %v, want %v", delay, tt.wantDelay)
+			}
+			if tt.wantDelay == 0 && (delay < d.BaseBackoff || delay > d.BaseBackoff*6/5) {
+				t.Fatalf("retry in %v, want the %v backoff with up to 20%% jitter", delay, d.BaseBackoff)
+			}
+		})
+	}
+}
+
+func TestDispatcherDeadLettersAfterMaxAttempts(t *testing.T) {
+	store := newFakeStore(1)
+	failure := errors.NewAPIError("Webhook returned status 503", nil)
+	n := &fakeNotifier{errs: []error{failure, failure, failure, failure}}
+	d := newTestDispatcher(store, n, 3)
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		d.dispatchDue(context.Background())
+		store.advance(d.MaxBackoff * 2)
+	}
+	d.dispatchDue(context.Background())
+
+	if e := store.entry(1); e.Status != models.OutboxStatusDead || e.Attempts != 3 {
+		t.Fatalf("entry is %s after %d attempts, want dead after 3", e.Status, e.Attempts)
+	}
+	if n.sends != 3 {
+		t.Fatalf("sent %d times, want 3", n.sends)
+	}
+
+	history := store.history()
+	for i, delivery := range history {
+		want := models.DeliveryStatusFailed
+		if i == len(history)-1 {
+			want = models.DeliveryStatusDead
+		}
+		if delivery.Attempt != i+1 || delivery.Status != want {
+			t.Fatalf("delivery %d is attempt %d %s, want attempt %d %s", i, delivery.Attempt, delivery.Status, i+1, want)
+		}
+	}
+}
+
+func TestDispatcherWaitsForLease(t *testing.T) {
+	store := newFakeStore(3)
+	n := &fakeNotifier{}
+	d := newTestDispatcher(store, n, 3)
+
+	// Another dispatcher claimed the entries and stopped before recording an outcome
+	if _, err := store.ClaimDueOutboxEntries(dispatchBatchSize, dispatchLease); err != nil {
+		t.Fatalf("ClaimDueOutboxEntries: %v", err)
+	}
+
+	d.dispatchDue(context.Background())
+	if n.sends != 0 {
+		t.Fatalf("sent %d leased entries, want none", n.sends)
+	}
+
+	// Once the lease runs out the entries are delivered
+	store.advance(dispatchLease)
+	d.dispatchDue(context.Background())
+	if n.sends != 3 {
+		t.Fatalf("sent %d entries after the lease ran out, want 3", n.sends)
+	}
+	for _, lease := range store.leases {
+		if lease != dispatchLease {
+			t.Fatalf("claimed with a %v lease, want %v", lease, dispatchLease)
+		}
+	}
+}
+
+func TestDispatcherClaimsFullBatchesUntilDrained(t *testing.T) {
+	store := newFakeStore(dispatchBatchSize*2 + 1)
+	n := &fakeNotifier{}
+	d := newTestDispatcher(store, n, 3)
+
+	d.dispatchDue(context.Background())
+
+	if n.sends != dispatchBatchSize*2+1 {
+		t.Fatalf("sent %d entries, want %d", n.sends, dispatchBatchSize*2+1)
+	}
+	if len(store.leases) != 3 {
+		t.Fatalf("claimed %d batches, want 3", len(store.leases))
+	}
+}
+
+func TestDispatcherBackoff(t *testing.T) {
+	d := &Dispatcher{BaseBackoff: 30 * time.Second, MaxBackoff: 4 * time.Minute}
+
+	for attempt, want := range map[int]time.Duration{
+		1: 30 * time.Second,
+		2: time.Minute,
+		3: 2 * time.Minute,
+		4: 4 * time.Minute,
+		8: 4 * time.Minute,
+	} {
+		delay := d.backoff(attempt)
+		if delay < want || delay > want*6/5 {
+			t.Fatalf("attempt %d backs off %v, want %v with up to 20%% jitter", attempt, delay, want)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/drmaq/streamnotification/internal/errors"
+	"github.com/drmaq/streamnotification/internal/models"
+)
+
+// Capabilities describes what a notification channel supports
+type Capabilities struct {
+	Type            models.NotificationType `json:"type"`
+	Name            string                  `json:"name"`
+	EventTypes      []string                `json:"event_types"`
+	DestinationHint string                  `json:"destination_hint"`
+
+	// DefaultTemplates are the message templates used when a setting has none, keyed by event type
+	DefaultTemplates map[string]string `json:"default_templates"`
+}
+
+// SupportsEvent checks if the channel can deliver an event type
+func (c Capabilities) SupportsEvent(eventType string) bool {
+	for _, t := range c.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Receipt describes the outcome of a delivery attempt. It may be returned
+// together with an error to report the HTTP status of a failed attempt.
+type Receipt struct {
+	StatusCode int    `json:"status_code"`
+	MessageID  string `json:"message_id,omitempty"`
+}
+
+// Notifier sends stream events to a notification channel
+type Notifier interface {
+	// Send delivers an event to the destination of a notification setting
+	Send(ctx context.Context, setting *models.NotificationSetting, event *models.StreamEvent) (*Receipt, error)
+
+	// ValidateDestination checks that a notification setting can be delivered to
+	ValidateDestination(setting *models.NotificationSetting) error
+
+	// Capabilities describes the channel
+	Capabilities() Capabilities
+}
+
+// Editor is implemented by notifiers that can edit a message they sent earlier
+type Editor interface {
+	// CanEdit checks if messages sent to the destination of a notification setting can be edited
+	CanEdit(setting *models.NotificationSetting) bool
+
+	// Edit replaces a sent message with one for a later event of the same stream
+	Edit(ctx context.Context, setting *models.NotificationSetting, messageID string, event *models.StreamEvent) (*Receipt, error)
+}
+
+// Registry holds the notifiers for each notification type
+type Registry struct {
+	mu        sync.RWMutex
+	notifiers map[models.NotificationType]Notifier
+}
+
+// NewRegistry creates an empty notifier registry
+func NewRegistry() *Registry {
+	return &Registry{
+		notifiers: make(map[models.NotificationType]Notifier),
+	}
+}
+
+// Register adds a notifier, replacing any notifier of the same type
+func (r *Registry) Register(n Notifier) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.notifiers[n.Capabilities().Type] = n
+}
+
+// Get returns the notifier for a notification type
+func (r *Registry) Get(notificationType models.NotificationType) (Notifier, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	n, ok := r.notifiers[notificationType]
+	return n, ok
+}
+
+// Editor returns the editor for a notification setting, if its notifier can edit
+// the messages sent to its destination
+func (r *Registry) Editor(setting *models.NotificationSetting) (Editor, bool) {
+	n, ok := r.Get(setting.Type)
+	if !ok {
+		return nil, false
+	}
+
+	editor, ok := n.(Editor)
+	if !ok || !editor.CanEdit(setting) {
+		return nil, false
+	}
+	return editor, true
+}
+
+// Capabilities returns the capabilities of every registered notifier, sorted by type
+func (r *Registry) Capabilities() []Capabilities {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	capabilities := make([]Capabilities, 0, len(r.notifiers))
+	for _, n := range r.notifiers {
+		capabilities = append(capabilities, n.Capabilities())
+	}
+
+	sort.Slice(capabilities, func(i, j int) bool {
+		return capabilities[i].Type < capabilities[j].Type
+	})
+
+	return capabilities
+}
+
+// Validate checks that a notification setting targets a registered notifier
+// and that the notifier accepts its destination and event types
+func (r *Registry) Validate(setting *models.NotificationSetting) error {
+	n, ok := r.Get(setting.Type)
+	if !ok {
+		return errors.NewValidationError(fmt.Sprintf("Unknown notification type: %s", setting.Type), nil)
+	}
+
+	capabilities := n.Capabilities()
+	for _, eventType := range setting.EventTypes {
+		if !capabilities.SupportsEvent(eventType) {
+			return errors.NewValidationError(
+				fmt.Sprintf("%s notifications do not support %s events", capabilities.Name, eventType), nil)
+		}
+	}
+
+	for eventType, text := range setting.Templates {
+		if !capabilities.SupportsEvent(eventType) {
+			return errors.NewValidationError(
+				fmt.Sprintf("%s notifications do not support %s templates", capabilities.Name, eventType), nil)
+		}
+		if text == "" {
+			continue
+		}
+		if err := ValidateTemplate(text, eventType); err != nil {
+			return errors.NewValidationError(fmt.Sprintf("Invalid %s template", eventType), err)
+		}
+	}
+
+	if err := setting.Filters.Validate(); err != nil {
+		return errors.NewValidationError("Invalid filters", err)
+	}
+
+	if err := n.ValidateDestination(setting); err != nil {
+		if errors.IsValidationError(err) {
+			return err
+		}
+		return errors.NewValidationError("Invalid destination", err)
+	}
+
+	return nil
+}
+
+// Preview renders the message a notification type would send for an event,
+// using the given template or the type's default when it is empty
+func (r *Registry) Preview(notificationType models.NotificationType, text string, event *models.StreamEvent) (string, error) {
+	n, ok := r.Get(notificationType)
+	if !ok {
+		return "", errors.NewValidationError(fmt.Sprintf("Unknown notification type: %s", notificationType), nil)
+	}
+
+	capabilities := n.Capabilities()
+	if !capabilities.SupportsEvent(event.EventType) {
+		return "", errors.NewValidationError(
+			fmt.Sprintf("%s notifications do not support %s events", capabilities.Name, event.EventType), nil)
+	}
+
+	if text == "" {
+		text = capabilities.DefaultTemplates[event.EventType]
+	}
+
+	return RenderTemplate(text, event)
+}
+
+// Send delivers an event using the notifier registered for the setting's type
+func (r *Registry) Send(ctx context.Context, setting *models.NotificationSetting, event *models.StreamEvent) (*Receipt, error) {
+	n, ok := r.Get(setting.Type)
+	if !ok {
+		return nil, errors.NewValidationError(fmt.Sprintf("Unknown notification type: %s", setting.Type), nil)
+	}
+
+	if !n.Capabilities().SupportsEvent(event.EventType) {
+		return nil, errors.NewValidationError(
+			fmt.Sprintf("%s notifications do not support %s events", setting.Type, event.EventType), nil)
+	}
+
+	return n.Send(ctx, setting, event)
+}
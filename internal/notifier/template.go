@@ -0,0 +1,148 @@
+package notifier
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/drmaq/streamnotification/internal/errors"
+	"github.com/drmaq/streamnotification/internal/models"
+)
+
+// TemplateData is the data available to message templates
+type TemplateData struct {
+	EventType     string
+	Streamer      string // Display name
+	Username      string
+	Title         string
+	Game          string
+	PreviousTitle string
+	PreviousGame  string
+	Viewers       int
+	PeakViewers   int
+	Milestone     int  // Viewer threshold crossed by a milestone
+	NewRecord     bool // Milestone beat the streamer's all-time peak
+	PreviousPeak  int  // All-time peak beaten by a new record
+	URL           string
+	ThumbnailURL  string
+	StartedAt     time.Time
+	EndedAt       time.Time
+	Duration      string
+}
+
+// NewTemplateData builds the template data for a stream event
+func NewTemplateData(event *models.StreamEvent) TemplateData {
+	data := TemplateData{
+		EventType:     event.EventType,
+		Streamer:      event.DisplayName,
+		Username:      event.Username,
+		Title:         event.StreamTitle,
+		Game:          event.GameName,
+		PreviousTitle: event.PreviousTitle,
+		PreviousGame:  event.PreviousGame,
+		Viewers:       event.ViewerCount,
+		PeakViewers:   event.PeakViewers,
+		Milestone:     event.Milestone,
+		NewRecord:     event.NewRecord,
+		PreviousPeak:  event.PreviousPeak,
+		URL:           fmt.Sprintf("https://twitch.tv/%s", event.Username),
+		ThumbnailURL:  event.ThumbnailURL,
+		StartedAt:     event.StartedAt,
+		Duration:      models.FormatDuration(event.Duration()),
+	}
+	if event.EndedAt != nil {
+		data.EndedAt = *event.EndedAt
+	}
+
+	return data
+}
+
+// templateFuncs are the helper functions available to message templates
+var templateFuncs = template.FuncMap{
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// RenderTemplate renders a message template for a stream event
+func RenderTemplate(text string, event *models.StreamEvent) (string, error) {
+	message, err := renderTemplate(text, event)
+	if err != nil {
+		return "", errors.NewValidationError("Failed to render template", err)
+	}
+
+	return message, nil
+}
+
+// ValidateTemplate checks that a template parses and renders against a sample event
+func ValidateTemplate(text, eventType string) error {
+	message, err := renderTemplate(text, SampleEvent(eventType))
+	if err != nil {
+		return err
+	}
+	if message == "" {
+		return fmt.Errorf("template renders an empty message")
+	}
+
+	return nil
+}
+
+// renderTemplate parses and executes a message template
+func renderTemplate(text string, event *models.StreamEvent) (string, error) {
+	tmpl, err := template.New("message").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, NewTemplateData(event)); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// RenderMessage renders the message for an event using the setting's template
+// for the event type, falling back to the channel's default template
+func RenderMessage(setting *models.NotificationSetting, event *models.StreamEvent, defaults map[string]string) (string, error) {
+	text := setting.Template(event.EventType)
+	if text == "" {
+		text = defaults[event.EventType]
+	}
+
+	return RenderTemplate(text, event)
+}
+
+// SampleEvent returns a stream event with example values for previews and validation
+func SampleEvent(eventType string) *models.StreamEvent {
+	endedAt := time.Now().Truncate(time.Minute)
+	startedAt := endedAt.Add(-3*time.Hour - 12*time.Minute)
+
+	event := &models.StreamEvent{
+		Username:    "example_streamer",
+		DisplayName: "Example_Streamer",
+		EventType:   eventType,
+		StreamTitle: "Example stream title",
+		GameName:    "Just Chatting",
+		ViewerCount: 1234,
+		PeakViewers: 2345,
+		StartedAt:   startedAt,
+	}
+	switch eventType {
+	case models.EventTypeOffline:
+		event.EndedAt = &endedAt
+	case models.EventTypeTitleChange:
+		event.PreviousTitle = "Previous stream title"
+	case models.EventTypeCategoryChange:
+		event.GameName = "Minecraft"
+		event.PreviousGame = "Just Chatting"
+	case models.EventTypeMilestone:
+		event.Milestone = 1000
+		event.PeakViewers = event.ViewerCount
+		event.NewRecord = true
+		event.PreviousPeak = 1200
+	}
+
+	return event
+}
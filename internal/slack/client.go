@@ -0,0 +1,231 @@
+package slack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/drmaq/streamnotification/internal/errors"
+	"github.com/drmaq/streamnotification/internal/logger"
+	"github.com/drmaq/streamnotification/internal/models"
+	"github.com/drmaq/streamnotification/internal/notifier"
+)
+
+// defaultTemplates are the message headers used when a notification setting has no template
+var defaultTemplates = map[string]string{
+	models.EventTypeLive:           "{{.Streamer}} is now live on Twitch!",
+	models.EventTypeOffline:        "{{.Streamer}} was live on Twitch",
+	models.EventTypeTitleChange:    "{{.Streamer}} changed the stream title",
+	models.EventTypeCategoryChange: "{{.Streamer}} switched to {{.Game}}",
+	models.EventTypeMilestone:      "{{.Streamer}} {{if .NewRecord}}set a new viewer record of {{.Viewers}}{{else}}reached {{.Milestone}} viewers{{end}}!",
+}
+
+// maxHeaderLength is the longest header block text Slack accepts
+const maxHeaderLength = 150
+
+// Client represents a Slack incoming webhook client
+type Client struct {
+	Logger     *logger.Logger
+	httpClient *http.Client
+}
+
+// NewClient creates a new Slack webhook client
+func NewClient(logger *logger.Logger) *Client {
+	return &Client{
+		Logger:     logger,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Capabilities describes the Slack notification channel
+func (c *Client) Capabilities() notifier.Capabilities {
+	return notifier.Capabilities{
+		Type:             models.NotificationTypeSlack,
+		Name:             "Slack",
+		EventTypes:       models.EventTypes,
+		DestinationHint:  "Slack incoming webhook URL",
+		DefaultTemplates: defaultTemplates,
+	}
+}
+
+// ValidateDestination checks that the destination is a Slack incoming webhook URL
+func (c *Client) ValidateDestination(setting *models.NotificationSetting) error {
+	u, err := url.Parse(setting.Destination)
+	if err != nil || u.Scheme != "https" {
+		return errors.NewValidationError("Slack destination must be an https webhook URL", err)
+	}
+
+	switch u.Host {
+	case "hooks.slack.com", "hooks.slack-gov.com":
+	default:
+		return errors.NewValidationError("Slack destination must be a hooks.slack.com webhook URL", nil)
+	}
+
+	if !strings.HasPrefix(u.Path, "/services/") {
+		return errors.NewValidationError("Slack destination must be an incoming webhook URL", nil)
+	}
+
+	return nil
+}
+
+// Text is a Slack text object
+type Text struct {
+	Type  string `json:"type"` // "plain_text" or "mrkdwn"
+	Text  string `json:"text"`
+	Emoji bool   `json:"emoji,omitempty"`
+}
+
+// Element is a Slack block element, such as an image or a button
+type Element struct {
+	Type     string `json:"type"`
+	Text     *Text  `json:"text,omitempty"`
+	URL      string `json:"url,omitempty"`
+	Style    string `json:"style,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+	AltText  string `json:"alt_text,omitempty"`
+}
+
+// Block is a Slack Block Kit layout block
+type Block struct {
+	Type      string    `json:"type"`
+	Text      *Text     `json:"text,omitempty"`
+	Fields    []Text    `json:"fields,omitempty"`
+	Accessory *Element  `json:"accessory,omitempty"`
+	Elements  []Element `json:"elements,omitempty"`
+}
+
+// WebhookMessage represents a Slack incoming webhook message
+type WebhookMessage struct {
+	Text   string  `json:"text"` // Fallback for notifications and clients without blocks
+	Blocks []Block `json:"blocks"`
+}
+
+// Send sends a notification to the Slack webhook of a notification setting
+func (c *Client) Send(ctx context.Context, setting *models.NotificationSetting, event *models.StreamEvent) (*notifier.Receipt, error) {
+	// Render the header
+	header, err := notifier.RenderMessage(setting, event, defaultTemplates)
+	if err != nil {
+		return nil, err
+	}
+	if len([]rune(header)) > maxHeaderLength {
+		header = string([]rune(header)[:maxHeaderLength-1]) + "…"
+	}
+
+	msg := WebhookMessage{Text: header}
+	if event.EventType == models.EventTypeOffline {
+		msg.Blocks = offlineBlocks(header, event)
+	} else {
+		msg.Blocks = liveBlocks(header, event)
+	}
+
+	// Marshal message to JSON
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal Slack message: %w", err)
+	}
+
+	// Send webhook request
+	req, err := http.NewRequestWithContext(ctx, "POST", setting.Destination, bytes.NewBuffer(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Slack webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send Slack webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	receipt := &notifier.Receipt{StatusCode: resp.StatusCode}
+
+	// Check response status, Slack explains errors in a plain text body such as "invalid_payload"
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		reason := fmt.Errorf("Slack webhook returned error status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+
+		switch resp.StatusCode {
+		case http.StatusTooManyRequests:
+			return receipt, errors.NewRateLimitError("Slack webhook is rate limited",
+				errors.ParseRetryAfter(resp.Header.Get("Retry-After")), reason)
+		case http.StatusNotFound, http.StatusGone:
+			// The webhook was removed or its channel archived
+			return receipt, errors.NewNotFoundError("Slack webhook no longer exists", reason)
+		case http.StatusBadRequest, http.StatusForbidden:
+			return receipt, errors.NewValidationError("Slack rejected the webhook message", reason)
+		}
+		return receipt, reason
+	}
+
+	c.Logger.Info("Sent Slack notification for %s", event.DisplayName)
+	return receipt, nil
+}
+
+// liveBlocks builds the message announcing that a streamer went live or changed their stream
+func liveBlocks(header string, event *models.StreamEvent) []Block {
+	streamURL := fmt.Sprintf("https://twitch.tv/%s", event.Username)
+
+	details := Block{
+		Type: "section",
+		Text: &Text{Type: "mrkdwn", Text: fmt.Sprintf("*<%s|%s>*", streamURL, escape(valueOr(event.StreamTitle, event.DisplayName)))},
+		Fields: []Text{
+			{Type: "mrkdwn", Text: "*Game*\n" + escape(valueOr(event.GameName, "Unknown"))},
+			{Type: "mrkdwn", Text: fmt.Sprintf("*Viewers*\n%d", event.ViewerCount)},
+		},
+	}
+	if event.ThumbnailURL != "" {
+		details.Accessory = &Element{
+			Type:     "image",
+			ImageURL: event.Thumbnail(320, 180),
+			AltText:  event.DisplayName + " stream thumbnail",
+		}
+	}
+
+	return []Block{
+		{Type: "header", Text: &Text{Type: "plain_text", Text: header, Emoji: true}},
+		details,
+		{
+			Type: "actions",
+			Elements: []Element{{
+				Type:  "button",
+				Text:  &Text{Type: "plain_text", Text: "Watch now"},
+				URL:   streamURL,
+				Style: "primary",
+			}},
+		},
+	}
+}
+
+// offlineBlocks builds the stream-ended summary message
+func offlineBlocks(header string, event *models.StreamEvent) []Block {
+	return []Block{
+		{Type: "header", Text: &Text{Type: "plain_text", Text: header, Emoji: true}},
+		{
+			Type: "section",
+			Text: &Text{Type: "mrkdwn", Text: escape(valueOr(event.StreamTitle, "Thanks for watching!"))},
+			Fields: []Text{
+				{Type: "mrkdwn", Text: "*Duration*\n" + models.FormatDuration(event.Duration())},
+				{Type: "mrkdwn", Text: fmt.Sprintf("*Peak viewers*\n%d", event.PeakViewers)},
+			},
+		},
+	}
+}
+
+// escape escapes the characters Slack treats as control characters in mrkdwn text
+func escape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// valueOr returns value, or fallback when value is empty
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,269 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/drmaq/streamnotification/internal/errors"
+	"github.com/drmaq/streamnotification/internal/logger"
+	"github.com/drmaq/streamnotification/internal/models"
+	"github.com/drmaq/streamnotification/internal/notifier"
+)
+
+// defaultTemplates are the message headlines used when a notification setting has no template
+var defaultTemplates = map[string]string{
+	models.EventTypeLive:           "{{.Streamer}} is now live on Twitch!",
+	models.EventTypeOffline:        "{{.Streamer}} was live on Twitch",
+	models.EventTypeTitleChange:    "{{.Streamer}} changed the stream title",
+	models.EventTypeCategoryChange: "{{.Streamer}} switched to {{.Game}}",
+	models.EventTypeMilestone:      "{{.Streamer}} {{if .NewRecord}}set a new viewer record of {{.Viewers}}{{else}}reached {{.Milestone}} viewers{{end}}!",
+}
+
+const (
+	telegramAPIBaseURL = "https://api.telegram.org"
+
+	// maxPartLength bounds the headline, title and game so a message stays well
+	// under Telegram's 4096 character limit
+	maxPartLength = 200
+
+	// maxCaptionLength is Telegram's photo caption limit, in UTF-16 code units
+	maxCaptionLength = 1024
+)
+
+// chatIDPattern matches numeric chat IDs and public @channel usernames
+var chatIDPattern = regexp.MustCompile(`^(-?[0-9]+|@[A-Za-z][A-Za-z0-9_]{4,})$`)
+
+// Client represents a Telegram Bot API client
+type Client struct {
+	Logger     *logger.Logger
+	botToken   string
+	apiBaseURL string
+	httpClient *http.Client
+}
+
+// NewClient creates a new Telegram Bot API client
+func NewClient(logger *logger.Logger, botToken string) *Client {
+	return &Client{
+		Logger:     logger,
+		botToken:   botToken,
+		apiBaseURL: telegramAPIBaseURL,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Capabilities describes the Telegram notification channel
+func (c *Client) Capabilities() notifier.Capabilities {
+	return notifier.Capabilities{
+		Type:             models.NotificationTypeTelegram,
+		Name:             "Telegram",
+		EventTypes:       models.EventTypes,
+		DestinationHint:  "Telegram chat ID or @channel username",
+		DefaultTemplates: defaultTemplates,
+	}
+}
+
+// ValidateDestination checks that the destination is a Telegram chat ID
+func (c *Client) ValidateDestination(setting *models.NotificationSetting) error {
+	if c.botToken == "" {
+		return errors.NewValidationError("Telegram bot token is not configured", nil)
+	}
+
+	if !chatIDPattern.MatchString(setting.Destination) {
+		return errors.NewValidationError("Telegram destination must be a chat ID such as -1001234567890 or an @channel username", nil)
+	}
+
+	return nil
+}
+
+// apiResponse is the envelope of every Bot API response
+type apiResponse struct {
+	OK          bool   `json:"ok"`
+	ErrorCode   int    `json:"error_code"`
+	Description string `json:"description"`
+	Result      struct {
+		MessageID int `json:"message_id"`
+	} `json:"result"`
+	Parameters struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+// Send sends a notification to the Telegram chat of a notification setting
+func (c *Client) Send(ctx context.Context, setting *models.NotificationSetting, event *models.StreamEvent) (*notifier.Receipt, error) {
+	// Check the bot token is configured
+	if c.botToken == "" {
+		return nil, errors.NewValidationError("Telegram bot token is not configured", nil)
+	}
+
+	// Render the headline
+	headline, err := notifier.RenderMessage(setting, event, defaultTemplates)
+	if err != nil {
+		return nil, err
+	}
+	text := formatMessage(headline, event)
+
+	// Live messages carry the stream thumbnail, unless the escaped text is too long for a caption
+	if event.EventType != models.EventTypeOffline && event.ThumbnailURL != "" && captionFits(text) {
+		receipt, err := c.call(ctx, "sendPhoto", map[string]interface{}{
+			"chat_id":    setting.Destination,
+			"photo":      thumbnailURL(event),
+			"caption":    text,
+			"parse_mode": "MarkdownV2",
+		})
+		if err == nil {
+			c.Logger.Info("Sent Telegram notification for %s", event.DisplayName)
+			return receipt, nil
+		}
+		if !isPhotoError(err) {
+			return receipt, err
+		}
+
+		// Telegram could not fetch the thumbnail, send the text alone
+		c.Logger.Warn("Telegram could not load the thumbnail of %s, sending text only: %v", event.DisplayName, err)
+	}
+
+	receipt, err := c.call(ctx, "sendMessage", map[string]interface{}{
+		"chat_id":    setting.Destination,
+		"text":       text,
+		"parse_mode": "MarkdownV2",
+	})
+	if err != nil {
+		return receipt, err
+	}
+
+	c.Logger.Info("Sent Telegram notification for %s", event.DisplayName)
+	return receipt, nil
+}
+
+// call invokes a Bot API method and translates Telegram errors into application errors
+func (c *Client) call(ctx context.Context, method string, params map[string]interface{}) (*notifier.Receipt, error) {
+	payload, err := json.Marshal(params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal Telegram request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/bot%s/%s", c.apiBaseURL, c.botToken, method), bytes.NewBuffer(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Telegram request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		// The request URL contains the bot token, keep it out of the error
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("failed to send Telegram %s request: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	receipt := &notifier.Receipt{StatusCode: resp.StatusCode}
+
+	var result apiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return receipt, fmt.Errorf("failed to decode Telegram response (status %d): %w", resp.StatusCode, err)
+	}
+
+	if result.OK {
+		receipt.MessageID = strconv.Itoa(result.Result.MessageID)
+		return receipt, nil
+	}
+
+	reason := fmt.Errorf("Telegram %s failed with error %d: %s", method, result.ErrorCode, result.Description)
+	switch {
+	case result.ErrorCode == http.StatusTooManyRequests:
+		return receipt, errors.NewRateLimitError("Telegram bot is rate limited",
+			time.Duration(result.Parameters.RetryAfter)*time.Second, reason)
+	case strings.Contains(result.Description, "chat not found"):
+		return receipt, errors.NewNotFoundError("Telegram chat not found", reason)
+	case result.ErrorCode == http.StatusUnauthorized || result.ErrorCode == http.StatusForbidden:
+		// An invalid bot token, or a bot that was removed from the chat
+		return receipt, errors.NewUnauthorizedError("Telegram bot is not allowed to post to the chat", reason)
+	case result.ErrorCode == http.StatusBadRequest:
+		return receipt, errors.NewValidationError("Telegram rejected the message", reason)
+	}
+	return receipt, reason
+}
+
+// isPhotoError checks if Telegram rejected a photo it could not download
+func isPhotoError(err error) bool {
+	return errors.IsValidationError(err) &&
+		(errors.ContainsError(err, "HTTP URL") || errors.ContainsError(err, "wrong type of the web page content"))
+}
+
+// formatMessage builds the MarkdownV2 message text for an event
+func formatMessage(headline string, event *models.StreamEvent) string {
+	streamURL := fmt.Sprintf("https://twitch.tv/%s", event.Username)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%s*\n", escape(truncate(headline, maxPartLength)))
+	if event.StreamTitle != "" {
+		fmt.Fprintf(&b, "%s\n", escape(truncate(event.StreamTitle, maxPartLength)))
+	}
+	b.WriteString("\n")
+
+	if event.EventType == models.EventTypeOffline {
+		fmt.Fprintf(&b, "Duration: %s\n", escape(models.FormatDuration(event.Duration())))
+		fmt.Fprintf(&b, "Peak viewers: %d\n", event.PeakViewers)
+	} else {
+		if event.GameName != "" {
+			fmt.Fprintf(&b, "Game: %s\n", escape(truncate(event.GameName, maxPartLength)))
+		}
+		fmt.Fprintf(&b, "Viewers: %d\n", event.ViewerCount)
+	}
+
+	fmt.Fprintf(&b, "[Watch on Twitch](%s)", escapeURL(streamURL))
+	return b.String()
+}
+
+// captionFits checks that message text fits a photo caption. Escapes are counted
+// too, which errs towards sending text messages.
+func captionFits(text string) bool {
+	return len(utf16.Encode([]rune(text))) <= maxCaptionLength
+}
+
+// thumbnailURL returns the stream thumbnail with a timestamp, so Telegram does not reuse a stale cached image
+func thumbnailURL(event *models.StreamEvent) string {
+	thumbnail := event.Thumbnail(1280, 720)
+	separator := "?"
+	if strings.Contains(thumbnail, "?") {
+		separator = "&"
+	}
+	return fmt.Sprintf("%s%st=%d", thumbnail, separator, time.Now().Unix())
+}
+
+// markdownEscaper escapes every character MarkdownV2 reserves outside entities
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "_", `\_`, "*", `\*`, "[", `\[`, "]", `\]`, "(", `\(`, ")", `\)`,
+	"~", `\~`, "`", "\\`", ">", `\>`, "#", `\#`, "+", `\+`, "-", `\-`, "=", `\=`,
+	"|", `\|`, "{", `\{`, "}", `\}`, ".", `\.`, "!", `\!`,
+)
+
+// escape escapes text for MarkdownV2
+func escape(text string) string {
+	return markdownEscaper.Replace(text)
+}
+
+// escapeURL escapes the URL part of a MarkdownV2 inline link, where only ')' and '\' are reserved
+func escapeURL(u string) string {
+	return strings.NewReplacer(`\`, `\\`, ")", `\)`).Replace(u)
+}
+
+// truncate shortens text to at most n runes, ending it with an ellipsis
+func truncate(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return string(runes[:n-1]) + "…"
+}
@@ -0,0 +1,176 @@
+package twitch
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/drmaq/streamnotification/internal/errors"
+)
+
+// tokenValidationInterval is how often access tokens are validated. Twitch
+// requires apps to validate their tokens at least hourly.
+const tokenValidationInterval = time.Hour
+
+// AuthStatus describes whether Twitch accepts the configured credentials
+type AuthStatus struct {
+	CredentialsValid bool       `json:"credentials_valid"`
+	Error            string     `json:"error,omitempty"`
+	UserTokenValid   *bool      `json:"user_token_valid,omitempty"`
+	ValidatedAt      *time.Time `json:"validated_at,omitempty"`
+}
+
+// AuthStatus returns whether Twitch accepts the configured credentials
+func (c *Client) AuthStatus() AuthStatus {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+
+	status := AuthStatus{CredentialsValid: c.credentialsErr == nil}
+	if c.credentialsErr != nil {
+		status.Error = c.credentialsErr.Error()
+	}
+	if c.userAccessToken != "" && !c.validatedAt.IsZero() {
+		valid := c.userTokenValid
+		status.UserTokenValid = &valid
+	}
+	if !c.validatedAt.IsZero() {
+		validatedAt := c.validatedAt
+		status.ValidatedAt = &validatedAt
+	}
+
+	return status
+}
+
+// setCredentialsError records whether Twitch rejected the client ID and secret
+func (c *Client) setCredentialsError(err error) {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+
+	c.credentialsErr = err
+}
+
+// currentAccessToken returns the cached app access token
+func (c *Client) currentAccessToken() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.accessToken
+}
+
+// reauthenticate discards a rejected app access token and gets a new one. The
+// cached token is only discarded if it is still the rejected one, so concurrent
+// requests failing with the same token authenticate once.
+func (c *Client) reauthenticate(rejected string) (string, error) {
+	c.mu.Lock()
+	if c.accessToken == rejected {
+		c.accessToken = ""
+	}
+	c.mu.Unlock()
+
+	if err := c.refreshAccessToken(); err != nil {
+		return "", err
+	}
+
+	return c.currentAccessToken(), nil
+}
+
+// retryUnauthorized re-authenticates after Twitch rejected the app access token
+// of a request, and updates the request to use the new token
+func (c *Client) retryUnauthorized(req *http.Request) error {
+	rejected := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+
+	c.logger.Warn("Twitch rejected the app access token, re-authenticating")
+	token, err := c.reauthenticate(rejected)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// validateToken checks an access token with Twitch, reporting false when it was rejected
+func (c *Client) validateToken(token string) (bool, error) {
+	req, err := http.NewRequest("GET", c.authBaseURL+"/validate", nil)
+	if err != nil {
+		return false, errors.NewAPIError("Failed to create validate request", err)
+	}
+	req.Header.Set("Authorization", "OAuth "+token)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return false, errors.NewAPIError("Failed to send validate request", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusUnauthorized:
+		return false, nil
+	default:
+		return false, errors.NewAPIError(
+			fmt.Sprintf("Twitch token validation failed with status %d", resp.StatusCode),
+			fmt.Errorf("unexpected status code: %d", resp.StatusCode),
+		)
+	}
+}
+
+// ValidateTokens validates the app and user access tokens, getting a new app
+// access token when Twitch revoked the current one
+func (c *Client) ValidateTokens() error {
+	token := c.currentAccessToken()
+	if token != "" {
+		valid, err := c.validateToken(token)
+		if err != nil {
+			return err
+		}
+		if !valid {
+			c.logger.Warn("Twitch app access token was revoked, re-authenticating")
+			if _, err := c.reauthenticate(token); err != nil {
+				return err
+			}
+		}
+	} else if err := c.refreshAccessToken(); err != nil {
+		return err
+	}
+
+	userTokenValid := false
+	if c.userAccessToken != "" {
+		valid, err := c.validateToken(c.userAccessToken)
+		if err != nil {
+			return err
+		}
+		if !valid {
+			c.logger.Error("Twitch rejected the user access token, EventSub WebSocket subscriptions will fail until it is replaced")
+		}
+		userTokenValid = valid
+	}
+
+	c.authMu.Lock()
+	c.userTokenValid = userTokenValid
+	c.validatedAt = time.Now()
+	c.authMu.Unlock()
+
+	return nil
+}
+
+// runTokenValidation validates access tokens now and then periodically until the context is cancelled
+func (c *Client) runTokenValidation(ctx context.Context) {
+	ticker := time.NewTicker(tokenValidationInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.ValidateTokens(); err != nil {
+			c.logger.Error("Failed to validate Twitch access tokens: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
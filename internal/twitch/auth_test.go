@@ -0,0 +1,101 @@
+package twitch
+
+import (
+	"testing"
+
+	"github.com/drmaq/streamnotification/internal/config"
+	"github.com/drmaq/streamnotification/internal/logger"
+	"github.com/drmaq/streamnotification/internal/notifier"
+	"github.com/drmaq/streamnotification/internal/twitch/fakehelix"
+)
+
+func TestNewClientFetchesAppToken(t *testing.T) {
+	sim := fakehelix.NewServer()
+	defer sim.Close()
+	client := newTestClient(t, sim)
+
+	token := client.currentAccessToken()
+	if token == "" {
+		t.Fatal("no app access token after NewClient")
+	}
+	if valid, err := client.validateToken(token); err != nil || !valid {
+		t.Fatalf("validateToken = %v, %v; want the issued token to be valid", valid, err)
+	}
+	if status := client.AuthStatus(); !status.CredentialsValid {
+		t.Fatalf("credentials reported invalid: %s", status.Error)
+	}
+}
+
+func TestNewClientRejectsInvalidCredentials(t *testing.T) {
+	sim := fakehelix.NewServer()
+	defer sim.Close()
+
+	_, err := NewClient(&config.Config{
+		TwitchClientID:     fakehelix.ClientID,
+		TwitchClientSecret: "wrong-secret",
+		TwitchAPIBaseURL:   sim.APIBaseURL(),
+		TwitchAuthBaseURL:  sim.AuthBaseURL(),
+	}, logger.NewLogger(), notifier.NewRegistry())
+	if err == nil {
+		t.Fatal("NewClient succeeded with an invalid client secret")
+	}
+}
+
+func TestRevokedTokenIsReplacedOn401(t *testing.T) {
+	sim := fakehelix.NewServer()
+	defer sim.Close()
+	client := newTestClient(t, sim)
+	sim.AddUser("alice", "Alice")
+
+	revoked := client.currentAccessToken()
+	sim.RevokeTokens()
+
+	// The request is rejected with 401, re-authenticated and retried
+	streamer, err := client.GetStreamerInfo("alice")
+	if err != nil {
+		t.Fatalf("GetStreamerInfo after revocation: %v", err)
+	}
+	if streamer.DisplayName != "Alice" {
+		t.Fatalf("got streamer %q, want Alice", streamer.DisplayName)
+	}
+	if token := client.currentAccessToken(); token == "" || token == revoked {
+		t.Fatal("app access token was not replaced")
+	}
+}
+
+func TestRevokedTokenWithResetSecretFails(t *testing.T) {
+	sim := fakehelix.NewServer()
+	defer sim.Close()
+	client := newTestClient(t, sim)
+	sim.AddUser("alice", "")
+
+	// The secret was reset, so re-authenticating fails too
+	sim.SetCredentials(fakehelix.ClientID, "new-secret")
+	sim.RevokeTokens()
+
+	if _, err := client.GetStreamerInfo("alice"); err == nil {
+		t.Fatal("GetStreamerInfo succeeded with a reset client secret")
+	}
+	if status := client.AuthStatus(); status.CredentialsValid {
+		t.Fatal("credentials reported valid after Twitch rejected them")
+	}
+}
+
+func TestValidateTokensReplacesRevokedToken(t *testing.T) {
+	sim := fakehelix.NewServer()
+	defer sim.Close()
+	client := newTestClient(t, sim)
+
+	revoked := client.currentAccessToken()
+	sim.RevokeTokens()
+
+	if err := client.ValidateTokens(); err != nil {
+		t.Fatalf("ValidateTokens: %v", err)
+	}
+	if token := client.currentAccessToken(); token == revoked {
+		t.Fatal("revoked app access token was kept")
+	}
+	if status := client.AuthStatus(); status.ValidatedAt == nil {
+		t.Fatal("validation time was not recorded")
+	}
+}
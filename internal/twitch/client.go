@@ -1,50 +1,116 @@
 package twitch
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/drmaq/streamnotification/internal/config"
 	"github.com/drmaq/streamnotification/internal/db"
-	"github.com/drmaq/streamnotification/internal/discord"
 	"github.com/drmaq/streamnotification/internal/errors"
 	"github.com/drmaq/streamnotification/internal/logger"
 	"github.com/drmaq/streamnotification/internal/models"
-	"github.com/drmaq/streamnotification/internal/twitter"
+	"github.com/drmaq/streamnotification/internal/notifier"
 )
 
 const (
-	twitchAPIBaseURL = "https://api.twitch.tv/helix"
-	twitchAuthURL    = "https://id.twitch.tv/oauth2/token"
-	monitorInterval  = 60 * time.Second // Check every minute
+	twitchAPIBaseURL  = "https://api.twitch.tv/helix"
+	twitchAuthBaseURL = "https://id.twitch.tv/oauth2"
+	monitorInterval   = 60 * time.Second // Check every minute
+
+	// reconcileInterval is the polling interval used when EventSub delivers transitions
+	reconcileInterval = 5 * time.Minute
+
+	// helixMaxBatchSize is the most logins or IDs Helix accepts in one request
+	helixMaxBatchSize = 100
+
+	// streamFetchConcurrency bounds the number of concurrent /streams requests
+	streamFetchConcurrency = 4
+
+	// offlineRetryDelay is how long to wait before re-checking a stream whose status could not be confirmed
+	offlineRetryDelay = time.Minute
 )
 
 // Client represents a Twitch API client
 type Client struct {
-	clientID      string
-	clientSecret  string
-	accessToken   string
-	tokenExpiry   time.Time
-	httpClient    *http.Client
-	logger        *logger.Logger
-	discordClient *discord.Client
-	twitterClient *twitter.Client
-	mu            sync.Mutex
+	clientID     string
+	clientSecret string
+	apiBaseURL   string
+	authBaseURL  string
+	accessToken  string
+	tokenExpiry  time.Time
+	httpClient   *http.Client
+	logger       *logger.Logger
+	notifiers    *notifier.Registry
+	mu           sync.Mutex
+
+	// Helix rate limit buckets and the current polling interval
+	appRateLimit    rateLimit
+	userRateLimit   rateLimit
+	currentInterval atomic.Int64
+
+	// Credential health, updated when authenticating and validating tokens
+	authMu         sync.Mutex
+	credentialsErr error // Set while Twitch rejects the client ID and secret
+	userTokenValid bool
+	validatedAt    time.Time
+
+	// EventSub configuration
+	eventSubTransport   string
+	eventSubCallbackURL string
+	eventSubSecret      string
+	eventSubWSURL       string
+	userAccessToken     string
+	seenMessages        map[string]time.Time
+	seenMu              sync.Mutex
+
+	// Stream timeline retention in days, 0 keeps samples forever
+	sampleDownsampleDays int
+	sampleRetentionDays  int
+
+	// stateMu serializes live/offline transitions between polling and EventSub
+	stateMu       sync.Mutex
+	offlineChecks map[int]*time.Timer // Pending grace period checks by streamer ID, guarded by stateMu
 }
 
 // NewClient creates a new Twitch API client
-func NewClient(cfg *config.Config, logger *logger.Logger, discordClient *discord.Client, twitterClient *twitter.Client) (*Client, error) {
+func NewClient(cfg *config.Config, logger *logger.Logger, notifiers *notifier.Registry) (*Client, error) {
 	client := &Client{
-		clientID:      cfg.TwitchClientID,
-		clientSecret:  cfg.TwitchClientSecret,
-		httpClient:    &http.Client{Timeout: 10 * time.Second},
-		logger:        logger,
-		discordClient: discordClient,
-		twitterClient: twitterClient,
+		clientID:     cfg.TwitchClientID,
+		clientSecret: cfg.TwitchClientSecret,
+		apiBaseURL:   strings.TrimSuffix(cfg.TwitchAPIBaseURL, "/"),
+		authBaseURL:  strings.TrimSuffix(cfg.TwitchAuthBaseURL, "/"),
+		httpClient:   &http.Client{Timeout: 10 * time.Second},
+		logger:       logger,
+		notifiers:    notifiers,
+
+		eventSubTransport:   cfg.TwitchEventSubTransport,
+		eventSubCallbackURL: cfg.TwitchEventSubCallbackURL,
+		eventSubSecret:      cfg.TwitchEventSubSecret,
+		eventSubWSURL:       cfg.TwitchEventSubWSURL,
+		userAccessToken:     cfg.TwitchUserAccessToken,
+		seenMessages:        make(map[string]time.Time),
+		offlineChecks:       make(map[int]*time.Timer),
+
+		sampleDownsampleDays: cfg.StreamSampleDownsampleDays,
+		sampleRetentionDays:  cfg.StreamSampleRetentionDays,
+	}
+
+	// Default to the public Twitch endpoints
+	if client.apiBaseURL == "" {
+		client.apiBaseURL = twitchAPIBaseURL
+	}
+	if client.authBaseURL == "" {
+		client.authBaseURL = twitchAuthBaseURL
 	}
 
 	// Get initial access token
@@ -66,8 +132,8 @@ func (c *Client) refreshAccessToken() error {
 	}
 
 	// Prepare request
-	url := fmt.Sprintf("%s?client_id=%s&client_secret=%s&grant_type=client_credentials",
-		twitchAuthURL, c.clientID, c.clientSecret)
+	url := fmt.Sprintf("%s/token?client_id=%s&client_secret=%s&grant_type=client_credentials",
+		c.authBaseURL, c.clientID, c.clientSecret)
 
 	req, err := http.NewRequest("POST", url, nil)
 	if err != nil {
@@ -83,10 +149,18 @@ func (c *Client) refreshAccessToken() error {
 
 	// Check response status
 	if resp.StatusCode != http.StatusOK {
-		return errors.NewAPIError(
+		err := errors.NewAPIError(
 			fmt.Sprintf("Twitch auth failed with status %d", resp.StatusCode),
 			fmt.Errorf("unexpected status code: %d", resp.StatusCode),
 		)
+
+		// Twitch answers 400 or 403 when the client ID or secret is invalid
+		switch resp.StatusCode {
+		case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden:
+			c.logger.Error("Twitch rejected the client credentials: %v", err)
+			c.setCredentialsError(err)
+		}
+		return err
 	}
 
 	// Parse response
@@ -102,6 +176,7 @@ func (c *Client) refreshAccessToken() error {
 	// Update token
 	c.accessToken = result.AccessToken
 	c.tokenExpiry = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+	c.setCredentialsError(nil)
 
 	return nil
 }
@@ -113,16 +188,43 @@ func (c *Client) getAuthenticatedRequest(method, endpoint string, body interface
 		return nil, errors.NewAPIError("Failed to refresh access token", err)
 	}
 
+	return c.newAPIRequest(method, endpoint, body, c.accessToken)
+}
+
+// getUserAuthenticatedRequest creates a new request authorized with the configured user access token
+func (c *Client) getUserAuthenticatedRequest(method, endpoint string, body interface{}) (*http.Request, error) {
+	if c.userAccessToken == "" {
+		return nil, errors.NewConfigError("Twitch user access token is not configured", nil)
+	}
+
+	return c.newAPIRequest(method, endpoint, body, c.userAccessToken)
+}
+
+// newAPIRequest creates a Twitch API request using the given bearer token
+func (c *Client) newAPIRequest(method, endpoint string, body interface{}, token string) (*http.Request, error) {
+	// Encode body if provided
+	var reqBody io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return nil, errors.NewInternalError("Failed to marshal API request body", err)
+		}
+		reqBody = bytes.NewReader(payload)
+	}
+
 	// Create request
-	url := twitchAPIBaseURL + endpoint
-	req, err := http.NewRequest(method, url, nil)
+	url := c.apiBaseURL + endpoint
+	req, err := http.NewRequest(method, url, reqBody)
 	if err != nil {
 		return nil, errors.NewAPIError("Failed to create API request", err)
 	}
 
 	// Add headers
 	req.Header.Add("Client-ID", c.clientID)
-	req.Header.Add("Authorization", "Bearer "+c.accessToken)
+	req.Header.Add("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
 	return req, nil
 }
@@ -142,7 +244,7 @@ func (c *Client) GetStreamerInfo(username string) (*models.Streamer, error) {
 	}
 
 	// Send request
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.doHelix(req)
 	if err != nil {
 		return nil, errors.NewAPIError("Failed to send request to Twitch API", err)
 	}
@@ -176,215 +278,791 @@ func (c *Client) GetStreamerInfo(username string) (*models.Streamer, error) {
 
 	// Create streamer
 	streamer := &models.Streamer{
-		Username:    result.Data[0].Login,
-		DisplayName: result.Data[0].DisplayName,
-		IsLive:      false,
+		TwitchUserID: result.Data[0].ID,
+		Username:     result.Data[0].Login,
+		DisplayName:  result.Data[0].DisplayName,
+		IsLive:       false,
 	}
 
 	return streamer, nil
 }
 
-// GetStreamStatus checks if a streamer is currently live
+// helixStream is a stream returned by the Helix /streams endpoint
+type helixStream struct {
+	ID           string    `json:"id"`
+	UserID       string    `json:"user_id"`
+	UserLogin    string    `json:"user_login"`
+	UserName     string    `json:"user_name"`
+	GameID       string    `json:"game_id"`
+	GameName     string    `json:"game_name"`
+	Type         string    `json:"type"`
+	Title        string    `json:"title"`
+	ViewerCount  int       `json:"viewer_count"`
+	StartedAt    time.Time `json:"started_at"`
+	Language     string    `json:"language"`
+	ThumbnailURL string    `json:"thumbnail_url"`
+}
+
+// GetStreamStatus checks which of the given streamers are currently live,
+// keyed by login
 func (c *Client) GetStreamStatus(usernames []string) (map[string]*models.StreamEvent, error) {
-	if len(usernames) == 0 {
-		return make(map[string]*models.StreamEvent), nil
+	return c.getStreamStatus("user_login", usernames, func(stream helixStream) string {
+		return stream.UserLogin
+	})
+}
+
+// GetStreamStatusByID checks which of the given Twitch users are currently live,
+// keyed by user ID. Unlike logins, user IDs do not change when a channel is renamed.
+func (c *Client) GetStreamStatusByID(userIDs []string) (map[string]*models.StreamEvent, error) {
+	return c.getStreamStatus("user_id", userIDs, func(stream helixStream) string {
+		return stream.UserID
+	})
+}
+
+// getStreamStatus looks up live streams by the given /streams query parameter.
+// Helix accepts at most 100 values per request, so larger rosters are split
+// into batches that are fetched concurrently and merged.
+func (c *Client) getStreamStatus(param string, values []string, key func(helixStream) string) (map[string]*models.StreamEvent, error) {
+	liveStreamers := make(map[string]*models.StreamEvent)
+	if len(values) == 0 {
+		return liveStreamers, nil
 	}
 
-	// Create query string
-	var endpoint string
-	for i, username := range usernames {
-		if i == 0 {
-			endpoint = fmt.Sprintf("/streams?user_login=%s", username)
-		} else {
-			endpoint += fmt.Sprintf("&user_login=%s", username)
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	sem := make(chan struct{}, streamFetchConcurrency)
+
+	for start := 0; start < len(values); start += helixMaxBatchSize {
+		end := start + helixMaxBatchSize
+		if end > len(values) {
+			end = len(values)
 		}
-	}
+		batch := values[start:end]
 
-	// Create request
-	req, err := c.getAuthenticatedRequest("GET", endpoint, nil)
-	if err != nil {
-		return nil, err // Error already wrapped
-	}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
 
-	// Send request
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, errors.NewAPIError("Failed to send request to Twitch API", err)
-	}
-	defer resp.Body.Close()
+			streams, err := c.fetchStreams(param, batch)
 
-	// Check response status
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.NewAPIError(
-			fmt.Sprintf("Twitch API request failed with status %d", resp.StatusCode),
-			fmt.Errorf("unexpected status code: %d", resp.StatusCode),
-		)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			for _, stream := range streams {
+				if stream.Type == "live" {
+					liveStreamers[key(stream)] = streamEvent(stream)
+				}
+			}
+		}()
 	}
 
-	// Parse response
-	var result struct {
-		Data []struct {
-			UserID       string    `json:"user_id"`
-			UserLogin    string    `json:"user_login"`
-			UserName     string    `json:"user_name"`
-			GameID       string    `json:"game_id"`
-			GameName     string    `json:"game_name"`
-			Type         string    `json:"type"`
-			Title        string    `json:"title"`
-			ViewerCount  int       `json:"viewer_count"`
-			StartedAt    time.Time `json:"started_at"`
-			ThumbnailURL string    `json:"thumbnail_url"`
-		} `json:"data"`
-	}
+	wg.Wait()
 
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, errors.NewAPIError("Failed to parse Twitch API response", err)
+	// A partial result would make missing streamers look offline
+	if firstErr != nil {
+		return nil, firstErr
 	}
 
-	// Create map of live streamers
-	liveStreamers := make(map[string]*models.StreamEvent)
-	for _, stream := range result.Data {
-		if stream.Type == "live" {
-			liveStreamers[stream.UserLogin] = &models.StreamEvent{
-				Username:     stream.UserLogin,
-				DisplayName:  stream.UserName,
-				StreamTitle:  stream.Title,
-				GameName:     stream.GameName,
-				ViewerCount:  stream.ViewerCount,
-				StartedAt:    stream.StartedAt,
-				ThumbnailURL: stream.ThumbnailURL,
-			}
+	return liveStreamers, nil
+}
+
+// fetchStreams gets the live streams for up to 100 logins or user IDs, following pagination cursors
+func (c *Client) fetchStreams(param string, values []string) ([]helixStream, error) {
+	var streams []helixStream
+	cursor := ""
+
+	for {
+		// Create query string
+		query := url.Values{}
+		for _, value := range values {
+			query.Add(param, value)
+		}
+		query.Set("first", strconv.Itoa(helixMaxBatchSize))
+		if cursor != "" {
+			query.Set("after", cursor)
+		}
+
+		// Create request
+		req, err := c.getAuthenticatedRequest("GET", "/streams?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err // Error already wrapped
+		}
+
+		// Send request
+		resp, err := c.doHelix(req)
+		if err != nil {
+			return nil, errors.NewAPIError("Failed to send request to Twitch API", err)
+		}
+
+		// Check response status
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, errors.NewAPIError(
+				fmt.Sprintf("Twitch API request failed with status %d", resp.StatusCode),
+				fmt.Errorf("unexpected status code: %d", resp.StatusCode),
+			)
+		}
+
+		// Parse response
+		var result struct {
+			Data       []helixStream `json:"data"`
+			Pagination struct {
+				Cursor string `json:"cursor"`
+			} `json:"pagination"`
+		}
+
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, errors.NewAPIError("Failed to parse Twitch API response", err)
+		}
+
+		streams = append(streams, result.Data...)
+
+		// Stop at the last page, or if Twitch keeps returning empty pages
+		if result.Pagination.Cursor == "" || result.Pagination.Cursor == cursor || len(result.Data) == 0 {
+			return streams, nil
 		}
+		cursor = result.Pagination.Cursor
 	}
+}
 
-	return liveStreamers, nil
+// streamEvent converts a Helix stream to a stream event
+func streamEvent(stream helixStream) *models.StreamEvent {
+	return &models.StreamEvent{
+		StreamID:     stream.ID,
+		Username:     stream.UserLogin,
+		DisplayName:  stream.UserName,
+		StreamTitle:  stream.Title,
+		GameName:     stream.GameName,
+		GameID:       stream.GameID,
+		Language:     stream.Language,
+		ViewerCount:  stream.ViewerCount,
+		StartedAt:    stream.StartedAt,
+		ThumbnailURL: stream.ThumbnailURL,
+	}
 }
 
-// StartMonitoring starts monitoring streamers for live status changes
+// StartMonitoring starts monitoring streamers for live status changes.
+// When EventSub webhooks are configured, polling is kept as a slower
+// reconciler that catches any transitions missed by the webhook. With the
+// WebSocket transport, transitions are driven entirely by the EventSub session.
 func (c *Client) StartMonitoring(ctx context.Context, database *db.Database) {
 	c.logger.Info("Starting Twitch stream monitor")
 
-	ticker := time.NewTicker(monitorInterval)
-	defer ticker.Stop()
+	// Backfill user IDs before anything subscribes to or polls streams by ID,
+	// then keep picking up channel renames
+	if err := c.RefreshStreamerIdentities(database); err != nil {
+		c.logger.Error("Failed to refresh streamer identities: %v", err)
+	}
+	go c.runIdentityRefresh(ctx, database)
+
+	// Catch access tokens revoked before they expire
+	go c.runTokenValidation(ctx)
+
+	// Downsample and expire old stream timelines
+	go c.runSampleRetention(ctx, database)
+
+	if c.eventSubTransport == "websocket" {
+		c.logger.Info("Using EventSub WebSocket transport")
+		c.NewEventSubSession(database, c.eventSubWSURL).Run(ctx)
+		c.logger.Info("Stopping Twitch stream monitor")
+		return
+	}
+
+	interval := monitorInterval
+	if c.webhooksEnabled() {
+		interval = reconcileInterval
+		c.logger.Info("EventSub webhooks enabled, polling every %v as a fallback", interval)
+		c.syncSubscriptions(database)
+	}
 
 	// Initial check
 	if err := c.checkStreamers(database); err != nil {
 		c.logger.Error("Failed to check streamers: %v", err)
 	}
 
+	// Stretch the interval while the rate limit budget is low
+	timer := time.NewTimer(c.nextPollInterval(interval))
+	defer timer.Stop()
+
 	for {
 		select {
 		case <-ctx.Done():
 			c.logger.Info("Stopping Twitch stream monitor")
 			return
-		case <-ticker.C:
+		case <-timer.C:
+			if c.webhooksEnabled() {
+				c.syncSubscriptions(database)
+			}
 			if err := c.checkStreamers(database); err != nil {
 				c.logger.Error("Failed to check streamers: %v", err)
 			}
+			timer.Reset(c.nextPollInterval(interval))
 		}
 	}
 }
 
 // checkStreamers checks the live status of all monitored streamers
 func (c *Client) checkStreamers(database *db.Database) error {
-	// Get all monitored streamers
-	streamers, err := database.GetStreamers()
+	return c.checkStreamersWhere(database, nil)
+}
+
+// checkStreamersWhere checks the live status of the monitored streamers selected
+// by include, or of all of them when include is nil
+func (c *Client) checkStreamersWhere(database *db.Database, include func(*models.Streamer) bool) error {
+	// Get the monitored streamers
+	all, err := database.GetStreamers()
 	if err != nil {
 		return errors.NewInternalError("Failed to get streamers from database", err)
 	}
 
-	if len(streamers) == 0 {
-		return nil
+	streamers := all[:0]
+	for i := range all {
+		if include == nil || include(&all[i]) {
+			streamers = append(streamers, all[i])
+		}
 	}
 
-	// Create list of usernames
-	usernames := make([]string, len(streamers))
-	usernameToID := make(map[string]int)
-	for i, streamer := range streamers {
-		usernames[i] = streamer.Username
-		usernameToID[streamer.Username] = streamer.ID
+	if len(streamers) == 0 {
+		return nil
 	}
 
 	// Get live status
-	liveStreamers, err := c.GetStreamStatus(usernames)
+	liveStreamers, err := c.getLiveStreamers(streamers)
 	if err != nil {
 		return errors.NewAPIError("Failed to get stream status", err)
 	}
 
-	// Get notification settings
-	notifications, err := database.GetNotificationSettings()
-	if err != nil {
-		return errors.NewInternalError("Failed to get notification settings", err)
-	}
+	// Serialize state transitions with EventSub notifications
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
 
 	// Update streamers
 	for i := range streamers {
+		// Reload in case an EventSub notification changed the streamer while checking
+		streamer, err := database.GetStreamer(streamers[i].ID)
+		if errors.IsNotFoundError(err) {
+			continue
+		}
+		if err != nil {
+			c.logger.Error("Failed to reload streamer %d: %v", streamers[i].ID, err)
+			continue
+		}
+
 		// Check if streamer is live
-		liveEvent, isLive := liveStreamers[streamers[i].Username]
-
-		// If status changed, update streamer
-		if isLive != streamers[i].IsLive {
-			oldStatus := streamers[i].IsLive
-			streamers[i].IsLive = isLive
-
-			// If went live, update last stream start and send notification
-			if isLive && !oldStatus {
-				// Update last stream start time
-				now := time.Now()
-				streamers[i].LastStreamStart = &now
-				streamers[i].LastNotificationSent = &now
-
-				// Update streamer in database
-				if err := database.UpdateStreamer(&streamers[i]); err != nil {
-					c.logger.Error("Failed to update streamer: %v", err)
-					continue
-				}
+		liveEvent, isLive := liveStreamers[streamer.ID]
 
-				// Set streamer ID in the event
-				liveEvent.StreamerID = streamers[i].ID
-
-				// Send notifications based on settings
-				c.logger.Info("%s went live playing %s", streamers[i].DisplayName, liveEvent.GameName)
-
-				// Track notification errors
-				var notificationErrors []error
-
-				// Send notifications to all enabled destinations
-				for _, notification := range notifications {
-					if !notification.Enabled {
-						continue
-					}
-
-					switch notification.Type {
-					case models.NotificationTypeDiscord:
-						// Send Discord notification
-						if c.discordClient != nil {
-							if err := c.discordClient.SendNotification(notification.Destination, liveEvent); err != nil {
-								c.logger.Error("Failed to send Discord notification: %v", err)
-								notificationErrors = append(notificationErrors, err)
-							}
-						}
-
-					case models.NotificationTypeTwitter:
-						// Send Twitter notification
-						if c.twitterClient != nil {
-							if err := c.twitterClient.SendNotification(liveEvent); err != nil {
-								c.logger.Error("Failed to send Twitter notification: %v", err)
-								notificationErrors = append(notificationErrors, err)
-							}
-						}
-					}
-				}
+		switch {
+		case isLive && !streamer.IsLive:
+			c.handleStreamOnline(database, streamer, liveEvent)
+		case isLive && restartedStream(streamer, liveEvent):
+			c.restartStream(database, streamer, liveEvent)
+		case isLive:
+			c.resumeStream(database, streamer)
+			c.updateLiveStats(database, streamer, liveEvent)
+		case streamer.IsLive:
+			c.handleStreamDown(database, streamer)
+		}
+	}
 
-				// Log notification status
-				if len(notificationErrors) > 0 {
-					c.logger.Warn("Sent notifications for %s with %d errors", streamers[i].DisplayName, len(notificationErrors))
-				} else {
-					c.logger.Info("Successfully sent all notifications for %s", streamers[i].DisplayName)
-				}
+	return nil
+}
+
+// getLiveStreamers returns the live streams of the given streamers, keyed by streamer ID.
+// Streamers are looked up by Twitch user ID, falling back to the login for rows whose
+// ID has not been backfilled yet.
+func (c *Client) getLiveStreamers(streamers []models.Streamer) (map[int]*models.StreamEvent, error) {
+	var userIDs, usernames []string
+	for _, streamer := range streamers {
+		if streamer.TwitchUserID != "" {
+			userIDs = append(userIDs, streamer.TwitchUserID)
+		} else {
+			usernames = append(usernames, streamer.Username)
+		}
+	}
+
+	byID, err := c.GetStreamStatusByID(userIDs)
+	if err != nil {
+		return nil, err
+	}
+	byLogin, err := c.GetStreamStatus(usernames)
+	if err != nil {
+		return nil, err
+	}
+
+	live := make(map[int]*models.StreamEvent)
+	for _, streamer := range streamers {
+		var event *models.StreamEvent
+		if streamer.TwitchUserID != "" {
+			event = byID[streamer.TwitchUserID]
+		} else {
+			event = byLogin[streamer.Username]
+		}
+		if event != nil {
+			live[streamer.ID] = event
+		}
+	}
+
+	return live, nil
+}
+
+// handleStreamOnline records that a streamer went live and queues notifications.
+// Callers must hold stateMu.
+func (c *Client) handleStreamOnline(database *db.Database, streamer *models.Streamer, liveEvent *models.StreamEvent) {
+	// Prefer the start time reported by Twitch so durations are accurate
+	now := time.Now()
+	startedAt := now
+	if !liveEvent.StartedAt.IsZero() {
+		startedAt = liveEvent.StartedAt
+	}
+
+	// A stream restarting shortly after the last one continues it without notifications
+	if c.inCooldown(streamer, liveEvent, now) {
+		c.continueStream(database, streamer, liveEvent, now)
+		return
+	}
+
+	streamer.IsLive = true
+	streamer.LastStreamStart = &startedAt
+	streamer.PeakViewers = liveEvent.ViewerCount
+	streamer.LastStreamTitle = liveEvent.StreamTitle
+	streamer.LastGameName = liveEvent.GameName
+	streamer.LastStreamID = liveEvent.StreamID
+	streamer.OfflineSince = nil
+	streamer.OfflineNotified = false
+	streamer.LastNotificationSent = &now
+	streamer.RecordViewersAtStart = streamer.RecordViewers
+	if liveEvent.ViewerCount > streamer.RecordViewers {
+		streamer.RecordViewers = liveEvent.ViewerCount
+	}
+
+	// Set streamer ID in the event
+	liveEvent.StreamerID = streamer.ID
+	liveEvent.EventType = models.EventTypeLive
+
+	c.logger.Info("%s went live playing %s", streamer.DisplayName, liveEvent.GameName)
+
+	if err := c.commitTransition(database, streamer, liveEvent); err != nil {
+		c.logger.Error("Failed to record %s going live: %v", streamer.DisplayName, err)
+		return
+	}
+
+	// Record the broadcast in the session history
+	session := *liveEvent
+	session.StartedAt = startedAt
+	if _, err := database.StartStreamSession(&session); err != nil {
+		c.logger.Error("Failed to start stream session for %s: %v", streamer.DisplayName, err)
+	}
+}
+
+// continueStream marks a streamer live again within the notification cooldown. The
+// previous stream and its session carry on, and since its go-live and offline
+// notifications were already queued, none are queued for the restart. Callers must hold stateMu.
+func (c *Client) continueStream(database *db.Database, streamer *models.Streamer, liveEvent *models.StreamEvent, now time.Time) {
+	c.logger.Info("Suppressing go-live notifications for %s: last notification was sent %v ago, continuing the previous stream",
+		streamer.DisplayName, now.Sub(*streamer.LastNotificationSent).Round(time.Second))
+
+	streamer.IsLive = true
+	streamer.OfflineSince = nil
+	streamer.OfflineNotified = true
+	if liveEvent.ViewerCount > streamer.PeakViewers {
+		streamer.PeakViewers = liveEvent.ViewerCount
+	}
+	if liveEvent.ViewerCount > streamer.RecordViewers {
+		streamer.RecordViewers = liveEvent.ViewerCount
+	}
+	if liveEvent.StreamTitle != "" {
+		streamer.LastStreamTitle = liveEvent.StreamTitle
+	}
+	if liveEvent.GameName != "" {
+		streamer.LastGameName = liveEvent.GameName
+	}
+	if liveEvent.StreamID != "" {
+		streamer.LastStreamID = liveEvent.StreamID
+	}
+
+	if err := database.UpdateStreamer(streamer); err != nil {
+		c.logger.Error("Failed to record %s going live: %v", streamer.DisplayName, err)
+		return
+	}
+
+	// Reopen the previous session, or start one if there is none
+	err := database.ResumeStreamSession(streamer.ID)
+	if errors.IsNotFoundError(err) {
+		session := *liveEvent
+		session.StreamerID = streamer.ID
+		if session.StartedAt.IsZero() {
+			session.StartedAt = now
+		}
+		_, err = database.StartStreamSession(&session)
+	}
+	if err != nil {
+		c.logger.Error("Failed to continue stream session for %s: %v", streamer.DisplayName, err)
+	}
+}
+
+// inCooldown reports whether a go-live notification was sent within the streamer's
+// cooldown for what looks like the same stream. A new Twitch stream ID always notifies.
+func (c *Client) inCooldown(streamer *models.Streamer, liveEvent *models.StreamEvent, now time.Time) bool {
+	if streamer.LastNotificationSent == nil || now.Sub(*streamer.LastNotificationSent) >= streamer.NotificationCooldown() {
+		return false
+	}
+
+	newStream := liveEvent.StreamID != "" && streamer.LastStreamID != "" && liveEvent.StreamID != streamer.LastStreamID
+	return !newStream
+}
+
+// restartedStream reports whether a live streamer is now broadcasting a different
+// Twitch stream than the one being tracked, as when they restart within the grace period
+func restartedStream(streamer *models.Streamer, liveEvent *models.StreamEvent) bool {
+	return streamer.IsLive && liveEvent.StreamID != "" && streamer.LastStreamID != "" &&
+		liveEvent.StreamID != streamer.LastStreamID
+}
+
+// restartStream ends the tracked stream of a streamer who started a new one, then
+// handles the new stream going live. Callers must hold stateMu.
+func (c *Client) restartStream(database *db.Database, streamer *models.Streamer, liveEvent *models.StreamEvent) {
+	c.logger.Info("%s started a new stream, ending the previous one", streamer.DisplayName)
+
+	endedAt := time.Now()
+	if streamer.OfflineSince != nil {
+		endedAt = *streamer.OfflineSince
+	}
+	if !c.handleStreamOffline(database, streamer, endedAt) {
+		return
+	}
+
+	c.handleStreamOnline(database, streamer, liveEvent)
+}
+
+// resumeStream clears a pending offline transition when a streamer comes back
+// within the grace period. Callers must hold stateMu.
+func (c *Client) resumeStream(database *db.Database, streamer *models.Streamer) {
+	if streamer.OfflineSince == nil {
+		return
+	}
+
+	c.logger.Info("%s is back after %v, continuing the stream", streamer.DisplayName,
+		time.Since(*streamer.OfflineSince).Round(time.Second))
+
+	streamer.OfflineSince = nil
+	if err := database.UpdateStreamer(streamer); err != nil {
+		c.logger.Error("Failed to update streamer: %v", err)
+	}
+}
+
+// handleStreamDown handles a live streamer being reported offline. The stream is
+// only ended once it has stayed down for the streamer's grace period, so a dropped
+// connection does not trigger offline and go-live notifications. Callers must hold stateMu.
+func (c *Client) handleStreamDown(database *db.Database, streamer *models.Streamer) {
+	grace := streamer.OfflineGrace()
+	if grace <= 0 {
+		c.handleStreamOffline(database, streamer, time.Now())
+		return
+	}
+
+	if streamer.OfflineSince == nil {
+		now := time.Now()
+		streamer.OfflineSince = &now
+		if err := database.UpdateStreamer(streamer); err != nil {
+			c.logger.Error("Failed to update streamer: %v", err)
+			return
+		}
+
+		c.logger.Info("%s appears offline, waiting %v before ending the stream", streamer.DisplayName, grace)
+	}
+
+	elapsed := time.Since(*streamer.OfflineSince)
+	if elapsed >= grace {
+		c.handleStreamOffline(database, streamer, *streamer.OfflineSince)
+		return
+	}
+
+	c.scheduleOfflineCheck(database, streamer.ID, grace-elapsed)
+}
+
+// scheduleOfflineCheck ends a stream once its grace period has passed, unless the
+// streamer came back in the meantime. Without it, EventSub deployments would wait
+// for the next reconciliation poll. Callers must hold stateMu.
+func (c *Client) scheduleOfflineCheck(database *db.Database, streamerID int, delay time.Duration) {
+	if _, pending := c.offlineChecks[streamerID]; pending {
+		return
+	}
+
+	c.offlineChecks[streamerID] = time.AfterFunc(delay, func() {
+		c.runOfflineCheck(database, streamerID)
+	})
+}
+
+// runOfflineCheck confirms a stream is still down once its grace period has passed
+func (c *Client) runOfflineCheck(database *db.Database, streamerID int) {
+	c.stateMu.Lock()
+	delete(c.offlineChecks, streamerID)
+	c.stateMu.Unlock()
+
+	streamer, err := database.GetStreamer(streamerID)
+	if err != nil || !streamer.IsLive || streamer.OfflineSince == nil {
+		return
+	}
+
+	// Confirm the stream is still down before ending it
+	streams, statusErr := c.getLiveStreamers([]models.Streamer{*streamer})
+
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	// Reload in case another transition happened while checking
+	streamer, err = database.GetStreamer(streamerID)
+	if err != nil || !streamer.IsLive || streamer.OfflineSince == nil {
+		return
+	}
+
+	if statusErr != nil {
+		c.logger.Warn("Failed to confirm %s is offline, checking again in %v: %v", streamer.DisplayName, offlineRetryDelay, statusErr)
+		c.scheduleOfflineCheck(database, streamerID, offlineRetryDelay)
+		return
+	}
+
+	if _, isLive := streams[streamer.ID]; isLive {
+		c.resumeStream(database, streamer)
+		return
+	}
+	c.handleStreamDown(database, streamer)
+}
+
+// updateLiveStats tracks peak viewers and the latest title and game while a streamer is live,
+// queueing title, category change and milestone notifications. Callers must hold stateMu.
+func (c *Client) updateLiveStats(database *db.Database, streamer *models.Streamer, liveEvent *models.StreamEvent) {
+	c.recordSessionSample(database, streamer, liveEvent)
+
+	changed := false
+	var events []*models.StreamEvent
+
+	if liveEvent.ViewerCount > streamer.PeakViewers {
+		if event := milestoneEvent(streamer, liveEvent); event != nil {
+			c.logger.Info("%s reached %d viewers", streamer.DisplayName, liveEvent.ViewerCount)
+			events = append(events, event)
+		}
+		if liveEvent.ViewerCount > streamer.RecordViewers {
+			streamer.RecordViewers = liveEvent.ViewerCount
+		}
+		streamer.PeakViewers = liveEvent.ViewerCount
+		changed = true
+	}
+	if liveEvent.StreamTitle != "" && liveEvent.StreamTitle != streamer.LastStreamTitle {
+		if streamer.LastStreamTitle != "" {
+			c.logger.Info("%s changed the stream title to %q", streamer.DisplayName, liveEvent.StreamTitle)
+			event := changeEvent(streamer, liveEvent, models.EventTypeTitleChange)
+			event.PreviousTitle = streamer.LastStreamTitle
+			events = append(events, event)
+		}
+		streamer.LastStreamTitle = liveEvent.StreamTitle
+		changed = true
+	}
+	if liveEvent.GameName != "" && liveEvent.GameName != streamer.LastGameName {
+		if streamer.LastGameName != "" {
+			c.logger.Info("%s switched from %s to %s", streamer.DisplayName, streamer.LastGameName, liveEvent.GameName)
+			event := changeEvent(streamer, liveEvent, models.EventTypeCategoryChange)
+			event.PreviousGame = streamer.LastGameName
+			events = append(events, event)
+		}
+		streamer.LastGameName = liveEvent.GameName
+		changed = true
+	}
+
+	if !changed {
+		return
+	}
+
+	// Queue change and milestone notifications with the new state
+	if len(events) > 0 {
+		if err := c.commitTransition(database, streamer, events...); err != nil {
+			c.logger.Error("Failed to record changes of %s: %v", streamer.DisplayName, err)
+		}
+		return
+	}
+
+	if err := database.UpdateStreamer(streamer); err != nil {
+		c.logger.Error("Failed to update streamer: %v", err)
+	}
+}
+
+// changeEvent builds a change or milestone event for a live streamer
+func changeEvent(streamer *models.Streamer, liveEvent *models.StreamEvent, eventType string) *models.StreamEvent {
+	event := *liveEvent
+	event.StreamerID = streamer.ID
+	event.EventType = eventType
+	event.PeakViewers = streamer.PeakViewers
+	if streamer.LastStreamStart != nil {
+		event.StartedAt = *streamer.LastStreamStart
+	}
+
+	return &event
+}
+
+// milestoneEvent builds a milestone event when a live streamer's viewer count first
+// crosses one of their thresholds this stream, or first beats the all-time peak
+// they had when the stream started. It must be called before the stream peak is raised.
+func milestoneEvent(streamer *models.Streamer, liveEvent *models.StreamEvent) *models.StreamEvent {
+	milestone := streamer.CrossedMilestone(streamer.PeakViewers, liveEvent.ViewerCount)
+
+	// Only the first poll above the record is announced, and only for a stream that
+	// started below it. The stream peak stays above the record from then on.
+	record := streamer.RecordViewersAtStart
+	newRecord := record > 0 && liveEvent.ViewerCount > record && streamer.PeakViewers <= record
+	if milestone == 0 && !newRecord {
+		return nil
+	}
+
+	event := changeEvent(streamer, liveEvent, models.EventTypeMilestone)
+	event.PeakViewers = liveEvent.ViewerCount
+	event.Milestone = milestone
+	if newRecord {
+		event.NewRecord = true
+		event.PreviousPeak = record
+	}
+
+	return event
+}
+
+// recordSessionSample adds a viewer sample to the streamer's open session,
+// starting one if the streamer went live before session history was recorded
+func (c *Client) recordSessionSample(database *db.Database, streamer *models.Streamer, liveEvent *models.StreamEvent) {
+	liveEvent.StreamerID = streamer.ID
+
+	err := database.RecordStreamSample(liveEvent)
+	if errors.IsNotFoundError(err) {
+		session := *liveEvent
+		if session.StartedAt.IsZero() {
+			session.StartedAt = time.Now()
+			if streamer.LastStreamStart != nil {
+				session.StartedAt = *streamer.LastStreamStart
 			}
 		}
+		_, err = database.StartStreamSession(&session)
+	}
+
+	if err != nil {
+		c.logger.Error("Failed to record stream session sample for %s: %v", streamer.DisplayName, err)
+	}
+}
+
+// handleStreamOffline records that a streamer went offline at endedAt and queues a stream
+// summary for destinations that opted into offline notifications, reporting whether
+// the new state was saved. Callers must hold stateMu.
+func (c *Client) handleStreamOffline(database *db.Database, streamer *models.Streamer, endedAt time.Time) bool {
+	streamer.IsLive = false
+	streamer.LastStreamEnd = &endedAt
+	streamer.OfflineSince = nil
+
+	// Build the stream summary
+	offlineEvent := &models.StreamEvent{
+		StreamerID:  streamer.ID,
+		Username:    streamer.Username,
+		DisplayName: streamer.DisplayName,
+		EventType:   models.EventTypeOffline,
+		StreamTitle: streamer.LastStreamTitle,
+		GameName:    streamer.LastGameName,
+		PeakViewers: streamer.PeakViewers,
+		StreamID:    streamer.LastStreamID,
+		EndedAt:     &endedAt,
+	}
+	if streamer.LastStreamStart != nil {
+		offlineEvent.StartedAt = *streamer.LastStreamStart
+	}
+
+	c.logger.Info("%s went offline after %s", streamer.DisplayName, models.FormatDuration(offlineEvent.Duration()))
+
+	// A continued stream had its summary queued when it first ended
+	var err error
+	if streamer.OfflineNotified {
+		c.logger.Info("Skipping the offline summary of %s, it was sent before the stream was continued", streamer.DisplayName)
+		err = database.UpdateStreamer(streamer)
+	} else {
+		err = c.commitTransition(database, streamer, offlineEvent)
+	}
+	if err != nil {
+		c.logger.Error("Failed to record %s going offline: %v", streamer.DisplayName, err)
+		return false
+	}
+
+	if err := database.EndStreamSession(streamer.ID, endedAt); err != nil {
+		c.logger.Error("Failed to end stream session for %s: %v", streamer.DisplayName, err)
+	}
+	return true
+}
+
+// commitTransition saves a streamer's new state and queues the events for every
+// destination that wants them in one transaction, so a notification is never lost
+// when the state change is recorded
+func (c *Client) commitTransition(database *db.Database, streamer *models.Streamer, events ...*models.StreamEvent) error {
+	var entries []models.OutboxEntry
+	counts := make([]int, len(events))
+	for i, event := range events {
+		eventEntries, err := c.buildDeliveries(database, event)
+		if err != nil {
+			return errors.NewInternalError("Failed to build notifications", err)
+		}
+		counts[i] = len(eventEntries)
+		entries = append(entries, eventEntries...)
+	}
+
+	if err := database.UpdateStreamerAndEnqueue(streamer, entries); err != nil {
+		return err
 	}
 
+	for i, event := range events {
+		c.logger.Info("Queued %d %s notifications for %s", counts[i], event.EventType, streamer.DisplayName)
+	}
 	return nil
 }
+
+// buildDeliveries creates an outbox entry for each enabled destination that wants the event
+func (c *Client) buildDeliveries(database *db.Database, event *models.StreamEvent) ([]models.OutboxEntry, error) {
+	// Get the notification settings routed to this streamer
+	notifications, err := database.GetNotificationSettingsForStreamer(event.StreamerID)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []models.OutboxEntry
+	for _, notification := range notifications {
+		if !notification.Enabled || !notification.WantsEvent(event.EventType) {
+			continue
+		}
+
+		// Skip destinations whose filters reject the event
+		if !notification.Filters.Matches(event) {
+			c.logger.Debug("Skipping %s notification %d: filtered out %s event for %s",
+				notification.Type, notification.ID, event.EventType, event.DisplayName)
+			continue
+		}
+
+		// Skip destinations whose channel cannot deliver this event
+		n, ok := c.notifiers.Get(notification.Type)
+		if !ok || !n.Capabilities().SupportsEvent(event.EventType) {
+			c.logger.Warn("Skipping %s notification %d: %s events not supported", notification.Type, notification.ID, event.EventType)
+			continue
+		}
+
+		entries = append(entries, models.OutboxEntry{
+			NotificationSettingID: notification.ID,
+			StreamerID:            event.StreamerID,
+			EventType:             event.EventType,
+			Event:                 event,
+		})
+	}
+
+	return entries, nil
+}
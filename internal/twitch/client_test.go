@@ -0,0 +1,199 @@
+package twitch
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/drmaq/streamnotification/internal/config"
+	"github.com/drmaq/streamnotification/internal/logger"
+	"github.com/drmaq/streamnotification/internal/models"
+	"github.com/drmaq/streamnotification/internal/notifier"
+	"github.com/drmaq/streamnotification/internal/twitch/fakehelix"
+)
+
+// newTestClient creates a client talking to a fake Helix server
+func newTestClient(t *testing.T, sim *fakehelix.Server) *Client {
+	t.Helper()
+
+	client, err := NewClient(&config.Config{
+		TwitchClientID:     fakehelix.ClientID,
+		TwitchClientSecret: fakehelix.ClientSecret,
+		TwitchAPIBaseURL:   sim.APIBaseURL(),
+		TwitchAuthBaseURL:  sim.AuthBaseURL(),
+	}, logger.NewLogger(), notifier.NewRegistry())
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	return client
+}
+
+// addStreamers adds count users to the fake server, putting every live-th one live
+func addStreamers(t *testing.T, sim *fakehelix.Server, count, live int) []string {
+	t.Helper()
+
+	logins := make([]string, count)
+	for i := range logins {
+		logins[i] = fmt.Sprintf("streamer%03d", i)
+		sim.AddUser(logins[i], "")
+		if i%live == 0 {
+			if err := sim.GoLive(logins[i], "Stream", "Just Chatting", i); err != nil {
+				t.Fatalf("GoLive: %v", err)
+			}
+		}
+	}
+	return logins
+}
+
+func TestGetStreamStatusBatchesLogins(t *testing.T) {
+	sim := fakehelix.NewServer()
+	defer sim.Close()
+	client := newTestClient(t, sim)
+
+	// 250 logins take three batches
+	logins := addStreamers(t, sim, 250, 2)
+
+	live, err := client.GetStreamStatus(logins)
+	if err != nil {
+		t.Fatalf("GetStreamStatus: %v", err)
+	}
+	if len(live) != 125 {
+		t.Fatalf("got %d live streams, want 125", len(live))
+	}
+	for i, login := range logins {
+		event, ok := live[login]
+		if ok != (i%2 == 0) {
+			t.Fatalf("%s live = %v, want %v", login, ok, i%2 == 0)
+		}
+		if ok && event.ViewerCount != i {
+			t.Fatalf("%s has %d viewers, want %d", login, event.ViewerCount, i)
+		}
+	}
+}
+
+func TestGetStreamStatusFollowsCursor(t *testing.T) {
+	sim := fakehelix.NewServer()
+	defer sim.Close()
+	client := newTestClient(t, sim)
+
+	// Every stream of the batch is live, but only 7 are returned per page
+	sim.SetPageSize(7)
+	logins := addStreamers(t, sim, 40, 1)
+
+	live, err := client.GetStreamStatus(logins)
+	if err != nil {
+		t.Fatalf("GetStreamStatus: %v", err)
+	}
+	if len(live) != len(logins) {
+		t.Fatalf("got %d live streams, want %d", len(live), len(logins))
+	}
+}
+
+func TestGetStreamStatusByIDFollowsCursor(t *testing.T) {
+	sim := fakehelix.NewServer()
+	defer sim.Close()
+	client := newTestClient(t, sim)
+
+	sim.SetPageSize(10)
+	addStreamers(t, sim, 150, 1)
+
+	var ids []string
+	for _, user := range sim.Users() {
+		ids = append(ids, user.ID)
+	}
+
+	live, err := client.GetStreamStatusByID(ids)
+	if err != nil {
+		t.Fatalf("GetStreamStatusByID: %v", err)
+	}
+	for _, id := range ids {
+		if _, ok := live[id]; !ok {
+			t.Fatalf("user %s missing from live streams", id)
+		}
+	}
+}
+
+func TestGetStreamStatusFailsOnFailedBatch(t *testing.T) {
+	sim := fakehelix.NewServer()
+	defer sim.Close()
+	client := newTestClient(t, sim)
+
+	// Only the second batch fails, the first one must not be returned on its own
+	logins := addStreamers(t, sim, 150, 1)
+	sim.FailStreams(logins[120], http.StatusBadRequest)
+
+	live, err := client.GetStreamStatus(logins)
+	if err == nil {
+		t.Fatal("GetStreamStatus succeeded with a failing batch")
+	}
+	if live != nil {
+		t.Fatalf("got %d live streams with a failing batch, want none", len(live))
+	}
+}
+
+func TestMilestoneEventAnnouncesRecordOnce(t *testing.T) {
+	streamer := &models.Streamer{
+		ID:                   1,
+		RecordViewers:        100,
+		RecordViewersAtStart: 100,
+		PeakViewers:          50,
+	}
+
+	// Viewers climb past the record over several polls, raising both peaks as updateLiveStats does
+	records := 0
+	for _, viewers := range []int{90, 120, 150, 200} {
+		event := milestoneEvent(streamer, &models.StreamEvent{ViewerCount: viewers})
+		if event != nil && event.NewRecord {
+			records++
+			if event.PreviousPeak != 100 {
+				t.Fatalf("announced previous peak %d, want 100", event.PreviousPeak)
+			}
+		}
+		streamer.PeakViewers = viewers
+		if viewers > streamer.RecordViewers {
+			streamer.RecordViewers = viewers
+		}
+	}
+
+	if records != 1 {
+		t.Fatalf("announced %d records, want 1", records)
+	}
+}
+
+func TestMilestoneEventSkipsStreamStartingAboveRecord(t *testing.T) {
+	streamer := &models.Streamer{
+		ID:                   1,
+		RecordViewers:        150,
+		RecordViewersAtStart: 100,
+		PeakViewers:          150,
+	}
+
+	if event := milestoneEvent(streamer, &models.StreamEvent{ViewerCount: 180}); event != nil && event.NewRecord {
+		t.Fatal("announced a record for a stream that started above it")
+	}
+}
+
+func TestRestartedStream(t *testing.T) {
+	tests := []struct {
+		name     string
+		isLive   bool
+		storedID string
+		liveID   string
+		want     bool
+	}{
+		{"same stream", true, "100", "100", false},
+		{"new stream while live", true, "100", "200", true},
+		{"offline streamer", false, "100", "200", false},
+		{"no stored stream ID", true, "", "200", false},
+		{"no reported stream ID", true, "100", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			streamer := &models.Streamer{IsLive: tt.isLive, LastStreamID: tt.storedID}
+			if got := restartedStream(streamer, &models.StreamEvent{StreamID: tt.liveID}); got != tt.want {
+				t.Fatalf("restartedStream = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
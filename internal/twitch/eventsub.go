@@ -0,0 +1,490 @@
+package twitch
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/drmaq/streamnotification/internal/db"
+	"github.com/drmaq/streamnotification/internal/errors"
+	"github.com/drmaq/streamnotification/internal/models"
+)
+
+const (
+	// EventSub subscription types handled by the monitor
+	eventSubTypeStreamOnline  = "stream.online"
+	eventSubTypeStreamOffline = "stream.offline"
+
+	// EventSub message types sent in the Twitch-Eventsub-Message-Type header
+	eventSubMessageVerification = "webhook_callback_verification"
+	eventSubMessageNotification = "notification"
+	eventSubMessageRevocation   = "revocation"
+
+	// eventSubMaxMessageAge is how old a message may be before it is rejected as a replay
+	eventSubMaxMessageAge = 10 * time.Minute
+
+	// eventSubMaxBodySize limits the size of webhook request bodies
+	eventSubMaxBodySize = 1 << 20
+)
+
+// EventSubSubscription represents an EventSub subscription returned by Helix
+type EventSubSubscription struct {
+	ID        string            `json:"id"`
+	Status    string            `json:"status"`
+	Type      string            `json:"type"`
+	Version   string            `json:"version"`
+	Cost      int               `json:"cost"`
+	Condition EventSubCondition `json:"condition"`
+	Transport EventSubTransport `json:"transport"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// EventSubCondition holds the condition of a stream subscription
+type EventSubCondition struct {
+	BroadcasterUserID string `json:"broadcaster_user_id"`
+}
+
+// EventSubTransport describes how Twitch delivers notifications
+type EventSubTransport struct {
+	Method    string `json:"method"`
+	Callback  string `json:"callback,omitempty"`
+	Secret    string `json:"secret,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+}
+
+// eventSubStreamEvent is the event payload of stream.online and stream.offline notifications
+type eventSubStreamEvent struct {
+	ID                   string    `json:"id"`
+	BroadcasterUserID    string    `json:"broadcaster_user_id"`
+	BroadcasterUserLogin string    `json:"broadcaster_user_login"`
+	BroadcasterUserName  string    `json:"broadcaster_user_name"`
+	Type                 string    `json:"type"`
+	StartedAt            time.Time `json:"started_at"`
+}
+
+// webhooksEnabled reports whether EventSub webhooks are configured
+func (c *Client) webhooksEnabled() bool {
+	return c.eventSubTransport == "webhook"
+}
+
+// CreateEventSubSubscription subscribes to an EventSub stream event for a broadcaster
+func (c *Client) CreateEventSubSubscription(subType, broadcasterUserID string, transport EventSubTransport) (*EventSubSubscription, error) {
+	body := map[string]interface{}{
+		"type":      subType,
+		"version":   "1",
+		"condition": EventSubCondition{BroadcasterUserID: broadcasterUserID},
+		"transport": transport,
+	}
+
+	// WebSocket subscriptions must be created with a user access token
+	var req *http.Request
+	var err error
+	if transport.Method == "websocket" {
+		req, err = c.getUserAuthenticatedRequest("POST", "/eventsub/subscriptions", body)
+	} else {
+		req, err = c.getAuthenticatedRequest("POST", "/eventsub/subscriptions", body)
+	}
+	if err != nil {
+		return nil, err // Error already wrapped
+	}
+
+	resp, err := c.doHelix(req)
+	if err != nil {
+		return nil, errors.NewAPIError("Failed to send request to Twitch API", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		return nil, errors.NewAPIError(
+			fmt.Sprintf("EventSub subscription request failed with status %d", resp.StatusCode),
+			fmt.Errorf("unexpected status code: %d", resp.StatusCode),
+		)
+	}
+
+	var result struct {
+		Data []EventSubSubscription `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, errors.NewAPIError("Failed to parse Twitch API response", err)
+	}
+
+	if len(result.Data) == 0 {
+		return nil, errors.NewAPIError("EventSub subscription response was empty", nil)
+	}
+
+	return &result.Data[0], nil
+}
+
+// ListEventSubSubscriptions returns all EventSub subscriptions owned by the application
+func (c *Client) ListEventSubSubscriptions() ([]EventSubSubscription, error) {
+	var subscriptions []EventSubSubscription
+	cursor := ""
+
+	for {
+		endpoint := "/eventsub/subscriptions"
+		if cursor != "" {
+			endpoint += "?after=" + url.QueryEscape(cursor)
+		}
+
+		req, err := c.getAuthenticatedRequest("GET", endpoint, nil)
+		if err != nil {
+			return nil, err // Error already wrapped
+		}
+
+		resp, err := c.doHelix(req)
+		if err != nil {
+			return nil, errors.NewAPIError("Failed to send request to Twitch API", err)
+		}
+
+		var result struct {
+			Data       []EventSubSubscription `json:"data"`
+			Pagination struct {
+				Cursor string `json:"cursor"`
+			} `json:"pagination"`
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, errors.NewAPIError(
+				fmt.Sprintf("Twitch API request failed with status %d", resp.StatusCode),
+				fmt.Errorf("unexpected status code: %d", resp.StatusCode),
+			)
+		}
+
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, errors.NewAPIError("Failed to parse Twitch API response", err)
+		}
+
+		subscriptions = append(subscriptions, result.Data...)
+
+		if result.Pagination.Cursor == "" {
+			return subscriptions, nil
+		}
+		cursor = result.Pagination.Cursor
+	}
+}
+
+// DeleteEventSubSubscription removes an EventSub subscription
+func (c *Client) DeleteEventSubSubscription(id string) error {
+	return c.deleteEventSubSubscription(id, false)
+}
+
+// deleteEventSubSubscription removes an EventSub subscription, optionally using the user access token
+func (c *Client) deleteEventSubSubscription(id string, userToken bool) error {
+	endpoint := "/eventsub/subscriptions?id=" + url.QueryEscape(id)
+
+	var req *http.Request
+	var err error
+	if userToken {
+		req, err = c.getUserAuthenticatedRequest("DELETE", endpoint, nil)
+	} else {
+		req, err = c.getAuthenticatedRequest("DELETE", endpoint, nil)
+	}
+	if err != nil {
+		return err // Error already wrapped
+	}
+
+	resp, err := c.doHelix(req)
+	if err != nil {
+		return errors.NewAPIError("Failed to send request to Twitch API", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		return errors.NewAPIError(
+			fmt.Sprintf("EventSub delete request failed with status %d", resp.StatusCode),
+			fmt.Errorf("unexpected status code: %d", resp.StatusCode),
+		)
+	}
+
+	return nil
+}
+
+// syncSubscriptions logs the result of SyncEventSubSubscriptions
+func (c *Client) syncSubscriptions(database *db.Database) {
+	if err := c.SyncEventSubSubscriptions(database); err != nil {
+		c.logger.Error("Failed to sync EventSub subscriptions: %v", err)
+	}
+}
+
+// SyncEventSubSubscriptions makes sure every tracked streamer has enabled
+// stream.online and stream.offline webhook subscriptions pointing at our
+// callback, and removes subscriptions for streamers that are no longer tracked.
+func (c *Client) SyncEventSubSubscriptions(database *db.Database) error {
+	streamers, err := database.GetStreamers()
+	if err != nil {
+		return errors.NewInternalError("Failed to get streamers from database", err)
+	}
+
+	userIDs, err := c.broadcasterIDs(streamers)
+	if err != nil {
+		return err
+	}
+
+	// Build the set of subscriptions we want
+	wanted := make(map[string]bool)
+	for _, id := range userIDs {
+		wanted[eventSubTypeStreamOnline+":"+id] = true
+		wanted[eventSubTypeStreamOffline+":"+id] = true
+	}
+
+	existing, err := c.ListEventSubSubscriptions()
+	if err != nil {
+		return err
+	}
+
+	// Remove stale or broken subscriptions that point at our callback
+	active := make(map[string]bool)
+	for _, sub := range existing {
+		if sub.Transport.Method != "webhook" || sub.Transport.Callback != c.eventSubCallbackURL {
+			continue
+		}
+
+		key := sub.Type + ":" + sub.Condition.BroadcasterUserID
+		healthy := sub.Status == "enabled" || sub.Status == "webhook_callback_verification_pending"
+		if wanted[key] && healthy && !active[key] {
+			active[key] = true
+			continue
+		}
+
+		if err := c.DeleteEventSubSubscription(sub.ID); err != nil {
+			c.logger.Warn("Failed to delete EventSub subscription %s: %v", sub.ID, err)
+		}
+	}
+
+	// Create missing subscriptions
+	transport := EventSubTransport{
+		Method:   "webhook",
+		Callback: c.eventSubCallbackURL,
+		Secret:   c.eventSubSecret,
+	}
+
+	created := 0
+	for key := range wanted {
+		if active[key] {
+			continue
+		}
+
+		parts := strings.SplitN(key, ":", 2)
+		if _, err := c.CreateEventSubSubscription(parts[0], parts[1], transport); err != nil {
+			c.logger.Error("Failed to create %s subscription for broadcaster %s: %v", parts[0], parts[1], err)
+			continue
+		}
+		created++
+	}
+
+	if created > 0 {
+		c.logger.Info("Created %d EventSub subscriptions", created)
+	}
+
+	return nil
+}
+
+// EventSubHandler returns the HTTP handler that receives EventSub webhook callbacks
+func (c *Client) EventSubHandler(database *db.Database) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The callback only exists when EventSub uses the webhook transport
+		if !c.webhooksEnabled() {
+			http.NotFound(w, r)
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, eventSubMaxBodySize))
+		if err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		messageID := r.Header.Get("Twitch-Eventsub-Message-Id")
+		timestamp := r.Header.Get("Twitch-Eventsub-Message-Timestamp")
+		signature := r.Header.Get("Twitch-Eventsub-Message-Signature")
+
+		// Verify the message came from Twitch
+		if !c.verifyEventSubSignature(messageID, timestamp, body, signature) {
+			c.logger.Warn("Rejected EventSub message with invalid signature")
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		// Reject old messages to prevent replay attacks
+		sentAt, err := time.Parse(time.RFC3339Nano, timestamp)
+		if err != nil || time.Since(sentAt) > eventSubMaxMessageAge {
+			c.logger.Warn("Rejected stale EventSub message %s", messageID)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		var message struct {
+			Challenge    string               `json:"challenge"`
+			Subscription EventSubSubscription `json:"subscription"`
+			Event        json.RawMessage      `json:"event"`
+		}
+		if err := json.Unmarshal(body, &message); err != nil {
+			c.logger.Error("Failed to parse EventSub message: %v", err)
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		switch r.Header.Get("Twitch-Eventsub-Message-Type") {
+		case eventSubMessageVerification:
+			c.logger.Info("Verified EventSub %s subscription for broadcaster %s",
+				message.Subscription.Type, message.Subscription.Condition.BroadcasterUserID)
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(message.Challenge))
+
+		case eventSubMessageNotification:
+			// Acknowledge quickly; Twitch retries if we take too long
+			w.WriteHeader(http.StatusNoContent)
+
+			// Twitch may deliver the same notification more than once
+			if c.markMessageSeen(messageID) {
+				return
+			}
+			go c.handleEventSubNotification(database, message.Subscription.Type, message.Event)
+
+		case eventSubMessageRevocation:
+			c.logger.Warn("EventSub %s subscription for broadcaster %s was revoked: %s",
+				message.Subscription.Type, message.Subscription.Condition.BroadcasterUserID, message.Subscription.Status)
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+}
+
+// verifyEventSubSignature checks the HMAC-SHA256 signature of a webhook message
+func (c *Client) verifyEventSubSignature(messageID, timestamp string, body []byte, signature string) bool {
+	// Anyone could sign with an empty secret
+	if c.eventSubSecret == "" || messageID == "" || timestamp == "" || signature == "" {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(c.eventSubSecret))
+	mac.Write([]byte(messageID))
+	mac.Write([]byte(timestamp))
+	mac.Write(body)
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// markMessageSeen records a message ID and reports whether it was already seen
+func (c *Client) markMessageSeen(messageID string) bool {
+	c.seenMu.Lock()
+	defer c.seenMu.Unlock()
+
+	// Forget messages that are too old to be accepted anyway
+	now := time.Now()
+	for id, seenAt := range c.seenMessages {
+		if now.Sub(seenAt) > eventSubMaxMessageAge {
+			delete(c.seenMessages, id)
+		}
+	}
+
+	if _, ok := c.seenMessages[messageID]; ok {
+		return true
+	}
+	c.seenMessages[messageID] = now
+	return false
+}
+
+// handleEventSubNotification applies a stream.online or stream.offline event
+func (c *Client) handleEventSubNotification(database *db.Database, subType string, payload json.RawMessage) {
+	var event eventSubStreamEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		c.logger.Error("Failed to parse EventSub %s event: %v", subType, err)
+		return
+	}
+
+	switch subType {
+	case eventSubTypeStreamOnline:
+		c.applyStreamOnline(database, &event)
+	case eventSubTypeStreamOffline:
+		c.applyStreamOffline(database, &event)
+	default:
+		c.logger.Debug("Ignoring EventSub %s event", subType)
+	}
+}
+
+// applyStreamOnline handles a stream.online event for a tracked streamer
+func (c *Client) applyStreamOnline(database *db.Database, event *eventSubStreamEvent) {
+	if event.Type != "" && event.Type != "live" {
+		return
+	}
+
+	// The event only carries the broadcaster, so fetch title and game from Helix
+	liveEvent := &models.StreamEvent{
+		StreamID:    event.ID,
+		Username:    event.BroadcasterUserLogin,
+		DisplayName: event.BroadcasterUserName,
+		StartedAt:   event.StartedAt,
+	}
+	if streams, err := c.GetStreamStatusByID([]string{event.BroadcasterUserID}); err != nil {
+		c.logger.Warn("Failed to fetch stream details for %s: %v", event.BroadcasterUserLogin, err)
+	} else if stream, ok := streams[event.BroadcasterUserID]; ok {
+		liveEvent = stream
+	}
+
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	streamer, err := eventStreamer(database, event)
+	if err != nil {
+		c.logger.Warn("Received stream.online for untracked streamer %s: %v", event.BroadcasterUserLogin, err)
+		return
+	}
+
+	switch {
+	case restartedStream(streamer, liveEvent):
+		c.restartStream(database, streamer, liveEvent)
+	case streamer.IsLive:
+		c.resumeStream(database, streamer)
+	default:
+		c.handleStreamOnline(database, streamer, liveEvent)
+	}
+}
+
+// eventStreamer finds the tracked streamer an EventSub event is about, by user ID
+// and, for streamers whose ID has not been backfilled yet, by login
+func eventStreamer(database *db.Database, event *eventSubStreamEvent) (*models.Streamer, error) {
+	streamer, err := database.GetStreamerByTwitchUserID(event.BroadcasterUserID)
+	if err == nil || !errors.IsNotFoundError(err) {
+		return streamer, err
+	}
+
+	return database.GetStreamerByUsername(event.BroadcasterUserLogin)
+}
+
+// applyStreamOffline handles a stream.offline event for a tracked streamer
+func (c *Client) applyStreamOffline(database *db.Database, event *eventSubStreamEvent) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	streamer, err := eventStreamer(database, event)
+	if err != nil {
+		c.logger.Warn("Received stream.offline for untracked streamer %s: %v", event.BroadcasterUserLogin, err)
+		return
+	}
+
+	if !streamer.IsLive {
+		return
+	}
+
+	c.handleStreamDown(database, streamer)
+}
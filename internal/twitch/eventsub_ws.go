@@ -0,0 +1,418 @@
+package twitch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/drmaq/streamnotification/internal/db"
+	"github.com/drmaq/streamnotification/internal/errors"
+	"github.com/drmaq/streamnotification/internal/models"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// EventSub WebSocket message types
+	wsMessageWelcome      = "session_welcome"
+	wsMessageKeepalive    = "session_keepalive"
+	wsMessageReconnect    = "session_reconnect"
+	wsMessageNotification = "notification"
+	wsMessageRevocation   = "revocation"
+
+	// wsWelcomeTimeout is how long to wait for session_welcome after connecting
+	wsWelcomeTimeout = 10 * time.Second
+
+	// wsKeepaliveGrace is added to the keepalive timeout before treating the connection as dead
+	wsKeepaliveGrace = 5 * time.Second
+
+	// wsMaxBackoff caps the delay between reconnection attempts
+	wsMaxBackoff = 2 * time.Minute
+
+	// wsMaxSubscriptions is the number of enabled subscriptions a WebSocket session may hold
+	wsMaxSubscriptions = 300
+
+	// wsMaxTotalCost is the total cost allowed for WebSocket subscriptions. Stream
+	// subscriptions cost 1 unless the broadcaster authorized the app, so about
+	// 5 streamers can be followed over a WebSocket.
+	wsMaxTotalCost = 10
+)
+
+// wsMessage is an EventSub WebSocket message
+type wsMessage struct {
+	Metadata struct {
+		MessageID        string    `json:"message_id"`
+		MessageType      string    `json:"message_type"`
+		MessageTimestamp time.Time `json:"message_timestamp"`
+		SubscriptionType string    `json:"subscription_type"`
+	} `json:"metadata"`
+	Payload struct {
+		Session *struct {
+			ID                      string `json:"id"`
+			Status                  string `json:"status"`
+			KeepaliveTimeoutSeconds int    `json:"keepalive_timeout_seconds"`
+			ReconnectURL            string `json:"reconnect_url"`
+		} `json:"session"`
+		Subscription *EventSubSubscription `json:"subscription"`
+		Event        json.RawMessage       `json:"event"`
+	} `json:"payload"`
+}
+
+// wsConnection is a WebSocket connection that has received its session_welcome
+type wsConnection struct {
+	conn      *websocket.Conn
+	sessionID string
+	keepalive time.Duration
+}
+
+// wsSubscription is a subscription attached to the session
+type wsSubscription struct {
+	id   string
+	cost int
+}
+
+// EventSubSession maintains an EventSub WebSocket session and the
+// subscriptions attached to it
+type EventSubSession struct {
+	client       *Client
+	url          string
+	dialer       *websocket.Dialer
+	pollInterval time.Duration
+
+	// Database access, replaceable so the session can run without a database.
+	// check polls the streamers selected by include, or all of them when it is nil.
+	loadStreamers func() ([]models.Streamer, error)
+	check         func(include func(*models.Streamer) bool)
+	handleEvent   func(subType string, event json.RawMessage)
+
+	mu            sync.Mutex
+	sessionID     string
+	subscriptions map[string]wsSubscription // By "type:broadcaster_id"
+	cost          int                       // Total cost of the subscriptions
+}
+
+// NewEventSubSession creates a new EventSub WebSocket session manager for the given socket URL
+func (c *Client) NewEventSubSession(database *db.Database, url string) *EventSubSession {
+	return &EventSubSession{
+		client:        c,
+		url:           url,
+		dialer:        websocket.DefaultDialer,
+		pollInterval:  monitorInterval,
+		loadStreamers: database.GetStreamers,
+		check: func(include func(*models.Streamer) bool) {
+			if err := c.checkStreamersWhere(database, include); err != nil {
+				c.logger.Error("Failed to check streamers: %v", err)
+			}
+		},
+		handleEvent: func(subType string, event json.RawMessage) {
+			c.handleEventSubNotification(database, subType, event)
+		},
+		subscriptions: make(map[string]wsSubscription),
+	}
+}
+
+// Run keeps the session connected until the context is cancelled
+func (s *EventSubSession) Run(ctx context.Context) {
+	go s.runPolling(ctx)
+
+	backoff := time.Second
+	var current *wsConnection
+
+	for ctx.Err() == nil {
+		fresh := false
+		if current == nil {
+			conn, err := s.connect(ctx, s.url)
+			if err != nil {
+				s.client.logger.Error("Failed to connect to EventSub WebSocket: %v", err)
+				if !sleepContext(ctx, backoff) {
+					return
+				}
+				backoff *= 2
+				if backoff > wsMaxBackoff {
+					backoff = wsMaxBackoff
+				}
+				continue
+			}
+			current = conn
+			fresh = true
+			backoff = time.Second
+		}
+
+		next, err := s.serve(ctx, current, fresh)
+		current.conn.Close()
+		current = next
+
+		if err != nil && ctx.Err() == nil {
+			s.client.logger.Warn("EventSub WebSocket session ended: %v", err)
+		}
+	}
+}
+
+// runPolling polls live streamers, whose viewers, title and game EventSub does not
+// report, and streamers left without subscriptions, until the context is cancelled
+func (s *EventSubSession) runPolling(ctx context.Context) {
+	for sleepContext(ctx, s.client.nextPollInterval(s.pollInterval)) {
+		s.check(func(streamer *models.Streamer) bool {
+			return streamer.IsLive || !s.subscribed(streamer)
+		})
+	}
+}
+
+// subscribed reports whether the session holds both stream subscriptions of a streamer
+func (s *EventSubSession) subscribed(streamer *models.Streamer) bool {
+	if streamer.TwitchUserID == "" {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, online := s.subscriptions[eventSubTypeStreamOnline+":"+streamer.TwitchUserID]
+	_, offline := s.subscriptions[eventSubTypeStreamOffline+":"+streamer.TwitchUserID]
+	return online && offline
+}
+
+// connect dials the socket and waits for the session_welcome message
+func (s *EventSubSession) connect(ctx context.Context, url string) (*wsConnection, error) {
+	conn, _, err := s.dialer.DialContext(ctx, url, nil)
+	if err != nil {
+		return nil, errors.NewAPIError("Failed to dial EventSub WebSocket", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(wsWelcomeTimeout))
+	var msg wsMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		conn.Close()
+		return nil, errors.NewAPIError("Failed to read EventSub welcome message", err)
+	}
+
+	if msg.Metadata.MessageType != wsMessageWelcome || msg.Payload.Session == nil {
+		conn.Close()
+		return nil, errors.NewAPIError(
+			fmt.Sprintf("Expected session_welcome, got %s", msg.Metadata.MessageType), nil)
+	}
+
+	keepalive := time.Duration(msg.Payload.Session.KeepaliveTimeoutSeconds) * time.Second
+	if keepalive <= 0 {
+		keepalive = 10 * time.Second
+	}
+
+	s.client.logger.Info("Connected to EventSub WebSocket session %s", msg.Payload.Session.ID)
+
+	return &wsConnection{
+		conn:      conn,
+		sessionID: msg.Payload.Session.ID,
+		keepalive: keepalive,
+	}, nil
+}
+
+// serve processes messages on a welcomed connection. It returns the
+// replacement connection when Twitch asks us to reconnect, or nil when the
+// session is lost and must be re-established from scratch.
+func (s *EventSubSession) serve(ctx context.Context, current *wsConnection, fresh bool) (*wsConnection, error) {
+	s.mu.Lock()
+	s.sessionID = current.sessionID
+	if fresh {
+		// Subscriptions do not survive a new session
+		s.subscriptions = make(map[string]wsSubscription)
+		s.cost = 0
+	}
+	s.mu.Unlock()
+
+	// Close the connection when the context is cancelled so reads unblock
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			current.conn.Close()
+		case <-done:
+		}
+	}()
+
+	if fresh {
+		s.syncSubscriptions()
+
+		// Catch up on transitions that happened while we were disconnected
+		go s.check(nil)
+	}
+
+	// Periodically subscribe to newly added streamers
+	go func() {
+		ticker := time.NewTicker(reconcileInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				s.syncSubscriptions()
+			}
+		}
+	}()
+
+	for {
+		current.conn.SetReadDeadline(time.Now().Add(current.keepalive + wsKeepaliveGrace))
+
+		var msg wsMessage
+		if err := current.conn.ReadJSON(&msg); err != nil {
+			return nil, err
+		}
+
+		switch msg.Metadata.MessageType {
+		case wsMessageKeepalive:
+			// Nothing to do, the read deadline is extended on every message
+
+		case wsMessageNotification:
+			if msg.Payload.Subscription != nil && !s.client.markMessageSeen(msg.Metadata.MessageID) {
+				go s.handleEvent(msg.Payload.Subscription.Type, msg.Payload.Event)
+			}
+
+		case wsMessageReconnect:
+			if msg.Payload.Session == nil || msg.Payload.Session.ReconnectURL == "" {
+				return nil, errors.NewAPIError("Reconnect message did not include a URL", nil)
+			}
+
+			// Keep the old connection until the new one is welcomed
+			s.client.logger.Info("EventSub WebSocket requested reconnect")
+			next, err := s.connect(ctx, msg.Payload.Session.ReconnectURL)
+			if err != nil {
+				return nil, err
+			}
+			return next, nil
+
+		case wsMessageRevocation:
+			if sub := msg.Payload.Subscription; sub != nil {
+				s.client.logger.Warn("EventSub %s subscription for broadcaster %s was revoked: %s",
+					sub.Type, sub.Condition.BroadcasterUserID, sub.Status)
+
+				s.mu.Lock()
+				s.remove(sub.Type + ":" + sub.Condition.BroadcasterUserID)
+				s.mu.Unlock()
+			}
+
+		default:
+			s.client.logger.Debug("Ignoring EventSub WebSocket message type %s", msg.Metadata.MessageType)
+		}
+	}
+}
+
+// syncSubscriptions creates subscriptions for every tracked streamer, as far as
+// the session limits allow, and removes those for streamers that are no longer tracked
+func (s *EventSubSession) syncSubscriptions() {
+	streamers, err := s.loadStreamers()
+	if err != nil {
+		s.client.logger.Error("Failed to get streamers from database: %v", err)
+		return
+	}
+
+	userIDs, err := s.client.broadcasterIDs(streamers)
+	if err != nil {
+		s.client.logger.Error("Failed to resolve streamer IDs: %v", err)
+		return
+	}
+
+	type subscriptionKey struct {
+		subType       string
+		broadcasterID string
+	}
+	wanted := make(map[string]subscriptionKey)
+	for _, id := range userIDs {
+		for _, subType := range []string{eventSubTypeStreamOnline, eventSubTypeStreamOffline} {
+			wanted[subType+":"+id] = subscriptionKey{subType: subType, broadcasterID: id}
+		}
+	}
+
+	s.mu.Lock()
+	sessionID := s.sessionID
+	var stale []string
+	for key, sub := range s.subscriptions {
+		if _, ok := wanted[key]; !ok {
+			stale = append(stale, sub.id)
+			s.remove(key)
+		}
+	}
+	var missing []subscriptionKey
+	for key, want := range wanted {
+		if _, ok := s.subscriptions[key]; !ok {
+			missing = append(missing, want)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, subID := range stale {
+		if err := s.client.deleteEventSubSubscription(subID, true); err != nil {
+			s.client.logger.Warn("Failed to delete EventSub subscription %s: %v", subID, err)
+		}
+	}
+
+	// Keep both subscriptions of a streamer together, and the same streamers
+	// subscribed from one sync to the next, when not all of them fit
+	sort.Slice(missing, func(i, j int) bool {
+		if missing[i].broadcasterID != missing[j].broadcasterID {
+			return missing[i].broadcasterID < missing[j].broadcasterID
+		}
+		return missing[i].subType < missing[j].subType
+	})
+
+	transport := EventSubTransport{
+		Method:    "websocket",
+		SessionID: sessionID,
+	}
+
+	created, skipped := 0, 0
+	for _, want := range missing {
+		s.mu.Lock()
+		full := len(s.subscriptions) >= wsMaxSubscriptions || s.cost >= wsMaxTotalCost
+		s.mu.Unlock()
+		if full {
+			skipped++
+			continue
+		}
+
+		sub, err := s.client.CreateEventSubSubscription(want.subType, want.broadcasterID, transport)
+		if err != nil {
+			s.client.logger.Error("Failed to create %s subscription for broadcaster %s: %v", want.subType, want.broadcasterID, err)
+			continue
+		}
+
+		s.mu.Lock()
+		if s.sessionID == sessionID {
+			s.subscriptions[want.subType+":"+want.broadcasterID] = wsSubscription{id: sub.ID, cost: sub.Cost}
+			s.cost += sub.Cost
+		}
+		s.mu.Unlock()
+		created++
+	}
+
+	if created > 0 {
+		s.client.logger.Info("Created %d EventSub WebSocket subscriptions", created)
+	}
+	if skipped > 0 {
+		s.client.logger.Warn("%d of %d EventSub subscriptions exceed the WebSocket session limits, polling every %v instead; use the webhook transport for large rosters",
+			skipped, len(wanted), s.pollInterval)
+	}
+}
+
+// remove forgets a subscription. Callers must hold mu.
+func (s *EventSubSession) remove(key string) {
+	if sub, ok := s.subscriptions[key]; ok {
+		s.cost -= sub.cost
+		delete(s.subscriptions, key)
+	}
+}
+
+// sleepContext waits for the given duration and reports false if the context was cancelled first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,330 @@
+package twitch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/drmaq/streamnotification/internal/models"
+	"github.com/drmaq/streamnotification/internal/twitch/fakehelix"
+)
+
+// wsTimeout bounds every wait for the session under test
+const wsTimeout = 5 * time.Second
+
+// sessionHarness runs an EventSub WebSocket session against fake Helix and
+// EventSub WebSocket servers
+type sessionHarness struct {
+	sim        *fakehelix.Server
+	ws         *fakehelix.WebSocketServer
+	session    *EventSubSession
+	reconciles atomic.Int32
+
+	mu        sync.Mutex
+	streamers []models.Streamer
+	events    []string   // Types of the handled notifications
+	polls     [][]string // Logins selected by each poll
+}
+
+// newSessionHarness starts a session tracking the given logins, polling every pollInterval
+func newSessionHarness(t *testing.T, pollInterval time.Duration, logins ...string) *sessionHarness {
+	t.Helper()
+
+	h := &sessionHarness{
+		sim: fakehelix.NewServer(),
+		ws:  fakehelix.NewWebSocketServer(),
+	}
+	t.Cleanup(h.sim.Close)
+	t.Cleanup(h.ws.Close)
+
+	client := newTestClient(t, h.sim)
+	client.userAccessToken = h.sim.IssueUserToken()
+
+	for i, login := range logins {
+		user := h.sim.AddUser(login, "")
+		h.streamers = append(h.streamers, models.Streamer{ID: i + 1, Username: login, TwitchUserID: user.ID})
+	}
+
+	h.session = client.NewEventSubSession(nil, h.ws.WebSocketURL())
+	h.session.pollInterval = pollInterval
+	h.session.loadStreamers = func() ([]models.Streamer, error) {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		return append([]models.Streamer(nil), h.streamers...), nil
+	}
+	h.session.check = func(include func(*models.Streamer) bool) {
+		if include == nil {
+			h.reconciles.Add(1)
+			return
+		}
+
+		streamers, _ := h.session.loadStreamers()
+		var selected []string
+		for i := range streamers {
+			if include(&streamers[i]) {
+				selected = append(selected, streamers[i].Username)
+			}
+		}
+
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.polls = append(h.polls, selected)
+	}
+	h.session.handleEvent = func(subType string, event json.RawMessage) {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.events = append(h.events, subType)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.session.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return h
+}
+
+// connect waits for the session to connect and welcomes it
+func (h *sessionHarness) connect(t *testing.T, sessionID string) *fakehelix.WebSocketConn {
+	t.Helper()
+
+	conn, err := h.ws.NextConn(wsTimeout)
+	if err != nil {
+		t.Fatalf("session did not connect: %v", err)
+	}
+	if err := conn.Welcome(sessionID, 10*time.Second); err != nil {
+		t.Fatalf("Welcome: %v", err)
+	}
+	return conn
+}
+
+// setLive marks a streamer live or offline in the harness database
+func (h *sessionHarness) setLive(login string, live bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i := range h.streamers {
+		if h.streamers[i].Username == login {
+			h.streamers[i].IsLive = live
+		}
+	}
+}
+
+// lastPoll returns the logins selected by the latest poll, and whether there was one
+func (h *sessionHarness) lastPoll() ([]string, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.polls) == 0 {
+		return nil, false
+	}
+	return h.polls[len(h.polls)-1], true
+}
+
+// handled returns the types of the notifications handled so far
+func (h *sessionHarness) handled() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.events...)
+}
+
+// subscriptionCount returns the number of subscriptions the session holds
+func (h *sessionHarness) subscriptionCount() int {
+	h.session.mu.Lock()
+	defer h.session.mu.Unlock()
+	return len(h.session.subscriptions)
+}
+
+// eventually fails the test unless condition becomes true within wsTimeout
+func eventually(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(wsTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestEventSubSessionSubscribesOnWelcome(t *testing.T) {
+	h := newSessionHarness(t, time.Hour, "alice", "bob")
+	h.connect(t, "session-1")
+
+	eventually(t, "subscriptions", func() bool { return len(h.sim.Subscriptions()) == 4 })
+	for _, sub := range h.sim.Subscriptions() {
+		if sub.Transport.Method != "websocket" || sub.Transport.SessionID != "session-1" {
+			t.Fatalf("subscription %s has transport %+v, want websocket session-1", sub.ID, sub.Transport)
+		}
+	}
+	eventually(t, "reconcile", func() bool { return h.reconciles.Load() == 1 })
+}
+
+func TestEventSubSessionHandlesNotifications(t *testing.T) {
+	h := newSessionHarness(t, time.Hour, "alice")
+	conn := h.connect(t, "session-1")
+	eventually(t, "subscriptions", func() bool { return h.subscriptionCount() == 2 })
+
+	var online fakehelix.Subscription
+	for _, sub := range h.sim.Subscriptions() {
+		if sub.Type == eventSubTypeStreamOnline {
+			online = sub
+		}
+	}
+	event := map[string]string{"broadcaster_user_id": online.Condition.BroadcasterUserID, "type": "live"}
+
+	// Keepalives do not end the session, and redelivered messages are dropped
+	if err := conn.Keepalive(); err != nil {
+		t.Fatalf("Keepalive: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := conn.Notification("message-1", online, event); err != nil {
+			t.Fatalf("Notification: %v", err)
+		}
+	}
+	if err := conn.Keepalive(); err != nil {
+		t.Fatalf("Keepalive: %v", err)
+	}
+	if err := conn.Notification("message-2", online, event); err != nil {
+		t.Fatalf("Notification: %v", err)
+	}
+
+	eventually(t, "notifications", func() bool { return len(h.handled()) >= 2 })
+	time.Sleep(50 * time.Millisecond)
+	if events := h.handled(); len(events) != 2 || events[0] != eventSubTypeStreamOnline {
+		t.Fatalf("handled %v, want two %s notifications", events, eventSubTypeStreamOnline)
+	}
+}
+
+func TestEventSubSessionReconnect(t *testing.T) {
+	h := newSessionHarness(t, time.Hour, "alice")
+	old := h.connect(t, "session-1")
+	eventually(t, "subscriptions", func() bool { return len(h.sim.Subscriptions()) == 2 })
+
+	if err := old.Reconnect(h.ws.WebSocketURL()); err != nil {
+		t.Fatalf("Reconnect: %v", err)
+	}
+
+	// The old connection stays open until the new one is welcomed
+	next := h.connect(t, "session-1")
+	if !old.WaitClosed(wsTimeout) {
+		t.Fatal("old connection was not closed after the reconnect")
+	}
+
+	// Subscriptions move with the session, so none are created and nothing is reconciled
+	if err := next.Keepalive(); err != nil {
+		t.Fatalf("Keepalive: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := len(h.sim.Subscriptions()); n != 2 {
+		t.Fatalf("got %d subscriptions after reconnect, want 2", n)
+	}
+	if n := h.subscriptionCount(); n != 2 {
+		t.Fatalf("session holds %d subscriptions after reconnect, want 2", n)
+	}
+	if n := h.reconciles.Load(); n != 1 {
+		t.Fatalf("reconciled %d times, want 1", n)
+	}
+}
+
+func TestEventSubSessionNewSessionAfterDrop(t *testing.T) {
+	h := newSessionHarness(t, time.Hour, "alice")
+	conn := h.connect(t, "session-1")
+	eventually(t, "subscriptions", func() bool { return len(h.sim.Subscriptions()) == 2 })
+
+	// A dropped connection starts a new session, which needs its own subscriptions
+	conn.Close()
+	h.connect(t, "session-2")
+
+	eventually(t, "new subscriptions", func() bool {
+		count := 0
+		for _, sub := range h.sim.Subscriptions() {
+			if sub.Transport.SessionID == "session-2" {
+				count++
+			}
+		}
+		return count == 2
+	})
+	eventually(t, "reconcile", func() bool { return h.reconciles.Load() == 2 })
+}
+
+func TestEventSubSessionRevocation(t *testing.T) {
+	h := newSessionHarness(t, time.Hour, "alice")
+	conn := h.connect(t, "session-1")
+	eventually(t, "subscriptions", func() bool { return h.subscriptionCount() == 2 })
+
+	sub := h.sim.Subscriptions()[0]
+	if err := conn.Revocation(sub, "authorization_revoked"); err != nil {
+		t.Fatalf("Revocation: %v", err)
+	}
+
+	eventually(t, "revoked subscription to be dropped", func() bool { return h.subscriptionCount() == 1 })
+	h.session.mu.Lock()
+	_, ok := h.session.subscriptions[sub.Type+":"+sub.Condition.BroadcasterUserID]
+	h.session.mu.Unlock()
+	if ok {
+		t.Fatalf("revoked %s subscription is still held", sub.Type)
+	}
+}
+
+func TestEventSubSessionCapsSubscriptionCost(t *testing.T) {
+	// 8 streamers need 16 subscriptions, but only 10 fit the cost limit
+	logins := make([]string, 8)
+	for i := range logins {
+		logins[i] = fmt.Sprintf("streamer%d", i)
+	}
+	h := newSessionHarness(t, time.Hour, logins...)
+	h.connect(t, "session-1")
+
+	eventually(t, "subscriptions", func() bool { return h.subscriptionCount() == wsMaxTotalCost })
+
+	// Streamers are subscribed to both events or none
+	perBroadcaster := make(map[string]int)
+	for _, sub := range h.sim.Subscriptions() {
+		perBroadcaster[sub.Condition.BroadcasterUserID]++
+	}
+	if len(perBroadcaster) != wsMaxTotalCost/2 {
+		t.Fatalf("subscribed to %d streamers, want %d", len(perBroadcaster), wsMaxTotalCost/2)
+	}
+	for id, count := range perBroadcaster {
+		if count != 2 {
+			t.Fatalf("streamer %s has %d subscriptions, want 2", id, count)
+		}
+	}
+
+	// The rest is left to polling
+	unsubscribed := 0
+	for _, streamer := range h.streamers {
+		if !h.session.subscribed(&streamer) {
+			unsubscribed++
+		}
+	}
+	if unsubscribed != 3 {
+		t.Fatalf("%d streamers are unsubscribed, want 3", unsubscribed)
+	}
+}
+
+func TestEventSubSessionPollsLiveAndUnsubscribedStreamers(t *testing.T) {
+	// 6 streamers need 12 subscriptions, one more streamer than fits
+	logins := []string{"a1", "a2", "a3", "a4", "a5", "a6"}
+	h := newSessionHarness(t, 20*time.Millisecond, logins...)
+	h.connect(t, "session-1")
+	eventually(t, "subscriptions", func() bool { return h.subscriptionCount() == wsMaxTotalCost })
+
+	// EventSub does not report viewers, title or game, so live streamers keep being polled
+	h.setLive("a2", true)
+
+	eventually(t, "poll of a2 and a6", func() bool {
+		polled, ok := h.lastPoll()
+		return ok && len(polled) == 2 && polled[0] == "a2" && polled[1] == "a6"
+	})
+}
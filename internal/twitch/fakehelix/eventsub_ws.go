@@ -0,0 +1,146 @@
+package fakehelix
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocketServer is a fake EventSub WebSocket server. It accepts connections
+// and hands them out through NextConn, so the messages sent on each connection
+// are chosen by the caller.
+type WebSocketServer struct {
+	*httptest.Server
+
+	upgrader websocket.Upgrader
+	conns    chan *WebSocketConn
+}
+
+// WebSocketConn is a client connection to a WebSocketServer
+type WebSocketConn struct {
+	conn      *websocket.Conn
+	sessionID string
+}
+
+// NewWebSocketServer starts a fake EventSub WebSocket server
+func NewWebSocketServer() *WebSocketServer {
+	s := &WebSocketServer{conns: make(chan *WebSocketConn, 16)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handleConnect))
+	return s
+}
+
+// WebSocketURL returns the ws:// URL clients connect to
+func (s *WebSocketServer) WebSocketURL() string {
+	return "ws" + strings.TrimPrefix(s.URL, "http")
+}
+
+// NextConn waits for the next client connection
+func (s *WebSocketServer) NextConn(timeout time.Duration) (*WebSocketConn, error) {
+	select {
+	case conn := <-s.conns:
+		return conn, nil
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("no connection within %v", timeout)
+	}
+}
+
+// handleConnect upgrades a connection and queues it for NextConn
+func (s *WebSocketServer) handleConnect(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	s.conns <- &WebSocketConn{conn: conn}
+}
+
+// Welcome sends session_welcome, starting the session with the given ID
+func (c *WebSocketConn) Welcome(sessionID string, keepalive time.Duration) error {
+	c.sessionID = sessionID
+	return c.send("session_welcome", "", map[string]interface{}{
+		"session": map[string]interface{}{
+			"id":                        sessionID,
+			"status":                    "connected",
+			"keepalive_timeout_seconds": int(keepalive / time.Second),
+			"reconnect_url":             nil,
+			"connected_at":              time.Now().UTC(),
+		},
+	})
+}
+
+// Keepalive sends session_keepalive
+func (c *WebSocketConn) Keepalive() error {
+	return c.send("session_keepalive", "", map[string]interface{}{})
+}
+
+// Reconnect sends session_reconnect, asking the client to move to reconnectURL
+func (c *WebSocketConn) Reconnect(reconnectURL string) error {
+	return c.send("session_reconnect", "", map[string]interface{}{
+		"session": map[string]interface{}{
+			"id":                        c.sessionID,
+			"status":                    "reconnecting",
+			"keepalive_timeout_seconds": nil,
+			"reconnect_url":             reconnectURL,
+			"connected_at":              time.Now().UTC(),
+		},
+	})
+}
+
+// Notification sends an event for a subscription, with the given message ID so
+// redeliveries can be simulated
+func (c *WebSocketConn) Notification(messageID string, subscription Subscription, event interface{}) error {
+	return c.sendID(messageID, "notification", subscription.Type, map[string]interface{}{
+		"subscription": subscription,
+		"event":        event,
+	})
+}
+
+// Revocation sends a revocation message for a subscription, with the reason as its status
+func (c *WebSocketConn) Revocation(subscription Subscription, status string) error {
+	subscription.Status = status
+	return c.send("revocation", subscription.Type, map[string]interface{}{
+		"subscription": subscription,
+	})
+}
+
+// WaitClosed reports whether the client closes the connection within timeout
+func (c *WebSocketConn) WaitClosed(timeout time.Duration) bool {
+	c.conn.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		if _, _, err := c.conn.ReadMessage(); err != nil {
+			netErr, ok := err.(interface{ Timeout() bool })
+			return !ok || !netErr.Timeout()
+		}
+	}
+}
+
+// Close closes the connection without a close message, as a dropped connection would
+func (c *WebSocketConn) Close() error {
+	return c.conn.Close()
+}
+
+// send writes a message with a new message ID
+func (c *WebSocketConn) send(messageType, subscriptionType string, payload interface{}) error {
+	return c.sendID(randomToken(), messageType, subscriptionType, payload)
+}
+
+// sendID writes a message
+func (c *WebSocketConn) sendID(messageID, messageType, subscriptionType string, payload interface{}) error {
+	metadata := map[string]interface{}{
+		"message_id":        messageID,
+		"message_type":      messageType,
+		"message_timestamp": time.Now().UTC(),
+	}
+	if subscriptionType != "" {
+		metadata["subscription_type"] = subscriptionType
+		metadata["subscription_version"] = "1"
+	}
+
+	return c.conn.WriteJSON(map[string]interface{}{
+		"metadata": metadata,
+		"payload":  payload,
+	})
+}
@@ -0,0 +1,637 @@
+// Package fakehelix is an in-memory stand-in for the Twitch Helix API and OAuth
+// endpoints. It serves users, streams, app access tokens and EventSub
+// subscriptions, and lets streams be switched live and offline, either directly
+// or from a script, so the twitch client can run without the real internet.
+// WebSocketServer stands in for the EventSub WebSocket endpoint.
+package fakehelix
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ClientID and ClientSecret are the credentials the server accepts by default
+	ClientID     = "fakehelix-client-id"
+	ClientSecret = "fakehelix-client-secret"
+
+	// rateLimitPoints is the size of the simulated rate limit bucket, refilled every minute
+	rateLimitPoints = 800
+
+	// defaultPageSize and maxPageSize bound the "first" parameter of paginated endpoints
+	defaultPageSize = 20
+	maxPageSize     = 100
+
+	// Largest total cost of the EventSub subscriptions of each transport. Every
+	// simulated subscription costs 1, as if no broadcaster had authorized the app.
+	maxWebhookCost   = 10000
+	maxWebSocketCost = 10
+)
+
+// User is a simulated Twitch user
+type User struct {
+	ID          string `json:"id"`
+	Login       string `json:"login"`
+	DisplayName string `json:"display_name"`
+}
+
+// Stream is a simulated live stream
+type Stream struct {
+	ID           string    `json:"id"`
+	UserID       string    `json:"user_id"`
+	UserLogin    string    `json:"user_login"`
+	UserName     string    `json:"user_name"`
+	GameID       string    `json:"game_id"`
+	GameName     string    `json:"game_name"`
+	Type         string    `json:"type"`
+	Title        string    `json:"title"`
+	ViewerCount  int       `json:"viewer_count"`
+	StartedAt    time.Time `json:"started_at"`
+	Language     string    `json:"language"`
+	ThumbnailURL string    `json:"thumbnail_url"`
+}
+
+// Subscription is a simulated EventSub subscription
+type Subscription struct {
+	ID        string `json:"id"`
+	Status    string `json:"status"`
+	Type      string `json:"type"`
+	Cost      int    `json:"cost"`
+	Condition struct {
+		BroadcasterUserID string `json:"broadcaster_user_id"`
+	} `json:"condition"`
+	Transport struct {
+		Method    string `json:"method"`
+		Callback  string `json:"callback,omitempty"`
+		SessionID string `json:"session_id,omitempty"`
+	} `json:"transport"`
+}
+
+// Server is a fake Twitch API server
+type Server struct {
+	*httptest.Server
+
+	// AutoCreateUsers makes unknown logins resolve to new users, which is
+	// handy when adding streamers by hand against a simulated API
+	AutoCreateUsers bool
+
+	mu            sync.Mutex
+	clientID      string
+	clientSecret  string
+	users         map[string]*User   // By ID
+	streams       map[string]*Stream // By user ID
+	tokens        map[string]bool    // Issued app access tokens that have not been revoked
+	userTokens    map[string]bool    // Issued user access tokens
+	subscriptions map[string]json.RawMessage
+	nextID        int
+	remaining     int
+	reset         time.Time
+	pageSize      int            // Largest page served, 0 for maxPageSize
+	streamErrors  map[string]int // Status /streams answers when asked about a login
+}
+
+// NewServer starts a fake Twitch API server accepting ClientID and ClientSecret
+func NewServer() *Server {
+	s := &Server{
+		clientID:      ClientID,
+		clientSecret:  ClientSecret,
+		users:         make(map[string]*User),
+		streams:       make(map[string]*Stream),
+		tokens:        make(map[string]bool),
+		userTokens:    make(map[string]bool),
+		subscriptions: make(map[string]json.RawMessage),
+		streamErrors:  make(map[string]int),
+		nextID:        1000,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/token", s.handleToken)
+	mux.HandleFunc("/oauth2/validate", s.handleValidate)
+	mux.HandleFunc("/helix/users", s.helix(s.handleUsers))
+	mux.HandleFunc("/helix/streams", s.helix(s.handleStreams))
+	mux.HandleFunc("/helix/eventsub/subscriptions", s.helix(s.handleSubscriptions))
+	s.Server = httptest.NewServer(mux)
+
+	return s
+}
+
+// APIBaseURL returns the Helix base URL to configure the twitch client with
+func (s *Server) APIBaseURL() string {
+	return s.URL + "/helix"
+}
+
+// AuthBaseURL returns the OAuth base URL to configure the twitch client with
+func (s *Server) AuthBaseURL() string {
+	return s.URL + "/oauth2"
+}
+
+// SetCredentials changes the client ID and secret the server accepts
+func (s *Server) SetCredentials(clientID, clientSecret string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.clientID = clientID
+	s.clientSecret = clientSecret
+}
+
+// SetPageSize caps the page size of paginated endpoints below the 100 Twitch allows,
+// so pagination can be exercised with few streams. 0 restores the Twitch limit.
+func (s *Server) SetPageSize(size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pageSize = size
+}
+
+// FailStreams makes /streams requests that include a user answer with an error status.
+// A status of 0 clears the failure.
+func (s *Server) FailStreams(login string, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if status == 0 {
+		delete(s.streamErrors, strings.ToLower(login))
+		return
+	}
+	s.streamErrors[strings.ToLower(login)] = status
+}
+
+// ExhaustRateLimit spends the rate limit bucket, which refills after resetIn
+func (s *Server) ExhaustRateLimit(resetIn time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.remaining = 0
+	s.reset = time.Now().Add(resetIn)
+}
+
+// RevokeTokens revokes every issued app access token, as Twitch does when an app's secret is reset
+func (s *Server) RevokeTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens = make(map[string]bool)
+}
+
+// IssueUserToken issues a user access token, which RevokeTokens leaves valid
+func (s *Server) IssueUserToken() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token := randomToken()
+	s.userTokens[token] = true
+	return token
+}
+
+// AddUser adds a user, returning it. An empty display name defaults to the login.
+func (s *Server) AddUser(login, displayName string) User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return *s.addUser(login, displayName)
+}
+
+// addUser adds a user. Callers must hold mu.
+func (s *Server) addUser(login, displayName string) *User {
+	login = strings.ToLower(login)
+	if displayName == "" {
+		displayName = login
+	}
+
+	user := &User{ID: s.newID(), Login: login, DisplayName: displayName}
+	s.users[user.ID] = user
+	return user
+}
+
+// RenameUser changes a user's login and display name, keeping its ID
+func (s *Server) RenameUser(login, newLogin, newDisplayName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user := s.userByLogin(login)
+	if user == nil {
+		return fmt.Errorf("unknown user: %s", login)
+	}
+
+	user.Login = strings.ToLower(newLogin)
+	user.DisplayName = newDisplayName
+	if stream, ok := s.streams[user.ID]; ok {
+		stream.UserLogin = user.Login
+		stream.UserName = user.DisplayName
+	}
+	return nil
+}
+
+// GoLive starts a stream for a user, or updates the stream if the user is already live
+func (s *Server) GoLive(login, title, game string, viewers int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user := s.userByLogin(login)
+	if user == nil {
+		return fmt.Errorf("unknown user: %s", login)
+	}
+
+	stream, ok := s.streams[user.ID]
+	if !ok {
+		stream = &Stream{
+			ID:        s.newID(),
+			UserID:    user.ID,
+			UserLogin: user.Login,
+			UserName:  user.DisplayName,
+			Type:      "live",
+			StartedAt: time.Now().UTC().Truncate(time.Second),
+			Language:  "en",
+			ThumbnailURL: fmt.Sprintf("https://static-cdn.jtvnw.net/previews-ttv/live_user_%s-{width}x{height}.jpg",
+				user.Login),
+		}
+		s.streams[user.ID] = stream
+	}
+
+	stream.Title = title
+	stream.GameName = game
+	stream.GameID = gameID(game)
+	stream.ViewerCount = viewers
+	return nil
+}
+
+// GoOffline ends a user's stream
+func (s *Server) GoOffline(login string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user := s.userByLogin(login)
+	if user == nil {
+		return fmt.Errorf("unknown user: %s", login)
+	}
+
+	delete(s.streams, user.ID)
+	return nil
+}
+
+// Users returns every simulated user
+func (s *Server) Users() []User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := make([]User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, *user)
+	}
+	return users
+}
+
+// Subscriptions returns the EventSub subscriptions
+func (s *Server) Subscriptions() []Subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subscriptions := make([]Subscription, 0, len(s.subscriptions))
+	for _, encoded := range s.subscriptions {
+		var subscription Subscription
+		json.Unmarshal(encoded, &subscription)
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions
+}
+
+// IsLive reports whether a user is streaming
+func (s *Server) IsLive(login string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user := s.userByLogin(login)
+	if user == nil {
+		return false
+	}
+	_, ok := s.streams[user.ID]
+	return ok
+}
+
+// userByLogin finds a user by login. Callers must hold mu.
+func (s *Server) userByLogin(login string) *User {
+	login = strings.ToLower(login)
+	for _, user := range s.users {
+		if user.Login == login {
+			return user
+		}
+	}
+	return nil
+}
+
+// newID returns a new numeric ID. Callers must hold mu.
+func (s *Server) newID() string {
+	s.nextID++
+	return strconv.Itoa(s.nextID)
+}
+
+// gameID derives a stable category ID from a game name
+func gameID(game string) string {
+	if game == "" {
+		return ""
+	}
+
+	id := 0
+	for _, r := range game {
+		id = (id*31 + int(r)) % 1000000
+	}
+	return strconv.Itoa(id)
+}
+
+// handleToken issues app access tokens for the client credentials grant
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Credentials may be sent in the query string or a form body
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	query := r.Form
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if query.Get("grant_type") != "client_credentials" {
+		writeError(w, http.StatusBadRequest, "unsupported grant type")
+		return
+	}
+	if query.Get("client_id") != s.clientID {
+		writeError(w, http.StatusBadRequest, "invalid client")
+		return
+	}
+	if query.Get("client_secret") != s.clientSecret {
+		writeError(w, http.StatusForbidden, "invalid client secret")
+		return
+	}
+
+	token := randomToken()
+	s.tokens[token] = true
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": token,
+		"expires_in":   3600,
+		"token_type":   "bearer",
+	})
+}
+
+// handleValidate validates an access token
+func (s *Server) handleValidate(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "OAuth ")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.tokens[token] && !s.userTokens[token] {
+		writeError(w, http.StatusUnauthorized, "invalid access token")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"client_id":  s.clientID,
+		"scopes":     []string{},
+		"expires_in": 3600,
+	})
+}
+
+// helix wraps a Helix handler with authentication and rate limit headers.
+// Handlers are called with mu held.
+func (s *Server) helix(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if r.Header.Get("Client-ID") != s.clientID || !(s.tokens[token] || s.userTokens[token]) {
+			writeError(w, http.StatusUnauthorized, "invalid OAuth token")
+			return
+		}
+
+		// Spend a point from the bucket
+		now := time.Now()
+		if !now.Before(s.reset) {
+			s.remaining = rateLimitPoints
+			s.reset = now.Add(time.Minute)
+		}
+		w.Header().Set("Ratelimit-Limit", strconv.Itoa(rateLimitPoints))
+		w.Header().Set("Ratelimit-Reset", strconv.FormatInt(s.reset.Unix(), 10))
+		if s.remaining == 0 {
+			w.Header().Set("Ratelimit-Remaining", "0")
+			writeError(w, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+		s.remaining--
+		w.Header().Set("Ratelimit-Remaining", strconv.Itoa(s.remaining))
+
+		handler(w, r)
+	}
+}
+
+// handleUsers looks up users by login or ID
+func (s *Server) handleUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	users := []User{}
+
+	for _, id := range query["id"] {
+		if user, ok := s.users[id]; ok {
+			users = append(users, *user)
+		}
+	}
+	for _, login := range query["login"] {
+		user := s.userByLogin(login)
+		if user == nil && s.AutoCreateUsers {
+			user = s.addUser(login, "")
+		}
+		if user != nil {
+			users = append(users, *user)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": users})
+}
+
+// handleStreams lists live streams by user login or ID, a page at a time
+func (s *Server) handleStreams(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	users := []*User{}
+
+	for _, id := range query["user_id"] {
+		if user, ok := s.users[id]; ok {
+			users = append(users, user)
+		}
+	}
+	for _, login := range query["user_login"] {
+		if user := s.userByLogin(login); user != nil {
+			users = append(users, user)
+		}
+	}
+
+	streams := []Stream{}
+	for _, user := range users {
+		if status, ok := s.streamErrors[user.Login]; ok {
+			writeError(w, status, "simulated failure")
+			return
+		}
+		if stream, ok := s.streams[user.ID]; ok {
+			streams = append(streams, *stream)
+		}
+	}
+	sort.Slice(streams, func(i, j int) bool { return streams[i].UserID < streams[j].UserID })
+
+	// Serve the page after the cursor, which is the offset of its first stream
+	size, offset, err := s.page(query.Get("first"), query.Get("after"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	pagination := map[string]string{}
+	if offset > len(streams) {
+		offset = len(streams)
+	}
+	end := offset + size
+	if end < len(streams) {
+		pagination["cursor"] = strconv.Itoa(end)
+	} else {
+		end = len(streams)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data":       streams[offset:end],
+		"pagination": pagination,
+	})
+}
+
+// page parses the "first" and "after" parameters of a paginated request. Callers must hold mu.
+func (s *Server) page(first, after string) (size, offset int, err error) {
+	size = defaultPageSize
+	if first != "" {
+		size, err = strconv.Atoi(first)
+		if err != nil || size < 1 || size > maxPageSize {
+			return 0, 0, fmt.Errorf("invalid first parameter: %s", first)
+		}
+	}
+	if s.pageSize > 0 && size > s.pageSize {
+		size = s.pageSize
+	}
+
+	if after != "" {
+		offset, err = strconv.Atoi(after)
+		if err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("invalid cursor: %s", after)
+		}
+	}
+
+	return size, offset, nil
+}
+
+// handleSubscriptions lists, creates and deletes EventSub subscriptions
+func (s *Server) handleSubscriptions(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		subscriptions := make([]json.RawMessage, 0, len(s.subscriptions))
+		for _, subscription := range s.subscriptions {
+			subscriptions = append(subscriptions, subscription)
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"data":       subscriptions,
+			"pagination": map[string]string{},
+		})
+
+	case http.MethodPost:
+		var subscription map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&subscription); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		// Each transport has its own cost budget
+		method := ""
+		if transport, ok := subscription["transport"].(map[string]interface{}); ok {
+			method, _ = transport["method"].(string)
+		}
+		maxCost := maxWebhookCost
+		if method == "websocket" {
+			maxCost = maxWebSocketCost
+		}
+		totalCost := s.totalCost(method)
+		if totalCost+1 > maxCost {
+			writeError(w, http.StatusTooManyRequests, "subscription cost exceeded")
+			return
+		}
+
+		id := randomToken()
+		subscription["id"] = id
+		subscription["status"] = "enabled"
+		subscription["cost"] = 1
+		subscription["created_at"] = time.Now().UTC()
+		encoded, _ := json.Marshal(subscription)
+		s.subscriptions[id] = encoded
+
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{
+			"data":           []json.RawMessage{encoded},
+			"total_cost":     totalCost + 1,
+			"max_total_cost": maxCost,
+		})
+
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if _, ok := s.subscriptions[id]; !ok {
+			writeError(w, http.StatusNotFound, "subscription not found")
+			return
+		}
+		delete(s.subscriptions, id)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// totalCost sums the cost of the subscriptions using a transport. Callers must hold mu.
+func (s *Server) totalCost(method string) int {
+	total := 0
+	for _, encoded := range s.subscriptions {
+		var subscription Subscription
+		json.Unmarshal(encoded, &subscription)
+		if subscription.Transport.Method == method {
+			total += subscription.Cost
+		}
+	}
+	return total
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// writeError writes an error in the format Twitch uses
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error":   http.StatusText(status),
+		"status":  status,
+		"message": message,
+	})
+}
+
+// randomToken returns a random hex token
+func randomToken() string {
+	b := make([]byte, 15)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,98 @@
+package fakehelix
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Transition is a scripted change to a user's stream
+type Transition struct {
+	After   time.Duration // Delay after the previous transition
+	Login   string
+	Live    bool
+	Title   string
+	Game    string
+	Viewers int
+}
+
+// Apply makes the transition's change on the server
+func (t Transition) Apply(s *Server) error {
+	if !t.Live {
+		return s.GoOffline(t.Login)
+	}
+	return s.GoLive(t.Login, t.Title, t.Game, t.Viewers)
+}
+
+// Play applies the transitions in order, waiting between them, until the
+// script ends or the context is cancelled. Unknown users are created first.
+func (s *Server) Play(ctx context.Context, script []Transition) error {
+	for _, t := range script {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(t.After):
+		}
+
+		s.ensureUser(t.Login)
+		if err := t.Apply(s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ensureUser adds a user unless one with the login exists
+func (s *Server) ensureUser(login string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.userByLogin(login) == nil {
+		s.addUser(login, "")
+	}
+}
+
+// demoGames are the categories simulated streams pick from
+var demoGames = []string{"Just Chatting", "Minecraft", "Fortnite", "Elden Ring", "Software and Game Development"}
+
+// RunDemo keeps every simulated user cycling between live and offline until the
+// context is cancelled. Each interval one user changes state, and live users'
+// viewer counts drift, which is enough to exercise notifications end to end.
+func (s *Server) RunDemo(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		users := s.Users()
+		if len(users) == 0 {
+			continue
+		}
+
+		// Flip one user's state
+		user := users[rand.Intn(len(users))]
+		if s.IsLive(user.Login) {
+			s.GoOffline(user.Login)
+		} else {
+			s.GoLive(user.Login, fmt.Sprintf("Simulated stream #%d", rand.Intn(1000)),
+				demoGames[rand.Intn(len(demoGames))], 10+rand.Intn(500))
+		}
+
+		// Drift viewer counts of the others
+		s.mu.Lock()
+		for _, stream := range s.streams {
+			stream.ViewerCount += rand.Intn(41) - 20
+			if stream.ViewerCount < 0 {
+				stream.ViewerCount = 0
+			}
+		}
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,267 @@
+package twitch
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// helixMaxRetries is how many times a rate limited or failed Helix request is retried
+	helixMaxRetries = 3
+
+	// helixRetryBaseDelay is the first retry delay, doubled for each further attempt
+	helixRetryBaseDelay = time.Second
+
+	// helixMaxWait caps how long a request waits for the rate limit bucket to refill
+	helixMaxWait = time.Minute
+
+	// rateLimitReserve is the number of points left unspent, so requests made by
+	// other processes sharing the client ID are not throttled
+	rateLimitReserve = 2
+)
+
+// rateLimit tracks a Helix token bucket from the Ratelimit-* response headers
+type rateLimit struct {
+	mu        sync.Mutex
+	limit     int
+	remaining int
+	reset     time.Time
+	updated   time.Time
+	throttled int // Responses with status 429
+}
+
+// RateLimitStatus is a snapshot of a Helix rate limit bucket
+type RateLimitStatus struct {
+	Limit     int        `json:"limit"`
+	Remaining int        `json:"remaining"`
+	ResetAt   *time.Time `json:"reset_at,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	Throttled int        `json:"throttled"`
+}
+
+// update records the bucket state reported by a Helix response
+func (r *rateLimit) update(header http.Header) {
+	limit, err := strconv.Atoi(header.Get("Ratelimit-Limit"))
+	if err != nil {
+		return
+	}
+	remaining, err := strconv.Atoi(header.Get("Ratelimit-Remaining"))
+	if err != nil {
+		return
+	}
+	reset, err := strconv.ParseInt(header.Get("Ratelimit-Reset"), 10, 64)
+	if err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.limit = limit
+	r.remaining = remaining
+	r.reset = time.Unix(reset, 0)
+	r.updated = time.Now()
+}
+
+// reserve takes a point from the bucket, returning how long to wait first
+// when the bucket is spent. An unknown or refilled bucket never waits.
+func (r *rateLimit) reserve() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if r.updated.IsZero() || !now.Before(r.reset) {
+		return 0
+	}
+
+	if r.remaining > rateLimitReserve {
+		r.remaining--
+		return 0
+	}
+
+	wait := r.reset.Sub(now)
+	if wait > helixMaxWait {
+		wait = helixMaxWait
+	}
+	return wait
+}
+
+// throttle records a 429 response and returns how long until the bucket refills
+func (r *rateLimit) throttle() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.throttled++
+	r.remaining = 0
+
+	wait := time.Until(r.reset)
+	if wait > helixMaxWait {
+		wait = helixMaxWait
+	}
+	return wait
+}
+
+// headroom returns the fraction of the bucket left, or 1 when it is unknown or has refilled
+func (r *rateLimit) headroom() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.updated.IsZero() || r.limit <= 0 || !time.Now().Before(r.reset) {
+		return 1
+	}
+
+	return float64(r.remaining) / float64(r.limit)
+}
+
+// status returns a snapshot of the bucket
+func (r *rateLimit) status() RateLimitStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := RateLimitStatus{
+		Limit:     r.limit,
+		Remaining: r.remaining,
+		Throttled: r.throttled,
+	}
+	if !r.updated.IsZero() {
+		reset, updated := r.reset, r.updated
+		status.ResetAt = &reset
+		status.UpdatedAt = &updated
+	}
+
+	return status
+}
+
+// rateLimitFor returns the bucket a request is charged to. App and user access
+// tokens have separate buckets.
+func (c *Client) rateLimitFor(req *http.Request) *rateLimit {
+	if c.userAccessToken != "" && req.Header.Get("Authorization") == "Bearer "+c.userAccessToken {
+		return &c.userRateLimit
+	}
+	return &c.appRateLimit
+}
+
+// doHelix sends a Helix request, waiting when the rate limit bucket is spent and
+// retrying 429 and 5xx responses with jittered backoff. A request rejected with
+// 401 is retried once with a new app access token. Other responses are returned
+// as-is; the caller must close the body.
+func (c *Client) doHelix(req *http.Request) (*http.Response, error) {
+	bucket := c.rateLimitFor(req)
+	reauthenticated := false
+
+	for attempt := 0; ; attempt++ {
+		if wait := bucket.reserve(); wait > 0 {
+			c.logger.Warn("Twitch API rate limit nearly spent, waiting %v", wait.Round(time.Second))
+			time.Sleep(wait)
+		}
+
+		// Rewind the body for retries
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		bucket.update(resp.Header)
+
+		// User access tokens cannot be refreshed, so only app token requests are retried
+		if resp.StatusCode == http.StatusUnauthorized && !reauthenticated && bucket == &c.appRateLimit {
+			resp.Body.Close()
+			reauthenticated = true
+			if err := c.retryUnauthorized(req); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+		if !retryable || attempt >= helixMaxRetries {
+			if resp.StatusCode == http.StatusTooManyRequests {
+				bucket.throttle()
+			}
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		// Back off exponentially, or until the bucket refills when throttled
+		wait := helixRetryBaseDelay << attempt
+		if resp.StatusCode == http.StatusTooManyRequests {
+			if reset := bucket.throttle(); reset > wait {
+				wait = reset
+			}
+		}
+		wait += time.Duration(rand.Int63n(int64(helixRetryBaseDelay)))
+
+		c.logger.Warn("Twitch API %s %s returned status %d, retrying in %v",
+			req.Method, req.URL.Path, resp.StatusCode, wait.Round(time.Millisecond))
+		time.Sleep(wait)
+	}
+}
+
+// pollInterval stretches the base polling interval while the app rate limit
+// bucket is low, so polling large rosters leaves room for other requests
+func (c *Client) pollInterval(base time.Duration) time.Duration {
+	headroom := c.appRateLimit.headroom()
+	switch {
+	case headroom < 0.1:
+		return base * 4
+	case headroom < 0.25:
+		return base * 2
+	default:
+		return base
+	}
+}
+
+// nextPollInterval returns the interval until the next poll, logging when it changes
+func (c *Client) nextPollInterval(base time.Duration) time.Duration {
+	interval := c.pollInterval(base)
+
+	previous := time.Duration(c.currentInterval.Swap(int64(interval)))
+	if previous != 0 && interval != previous {
+		c.logger.Info("Twitch API budget changed, polling every %v", interval)
+	}
+
+	return interval
+}
+
+// Status describes the Twitch client's credentials, rate limit budget and polling interval
+type Status struct {
+	Transport           string           `json:"transport"`
+	PollIntervalSeconds int              `json:"poll_interval_seconds"`
+	Auth                AuthStatus       `json:"auth"`
+	RateLimit           RateLimitStatus  `json:"rate_limit"`
+	UserRateLimit       *RateLimitStatus `json:"user_rate_limit,omitempty"`
+}
+
+// Status returns the current credential health, rate limit budget and polling interval
+func (c *Client) Status() Status {
+	interval := time.Duration(c.currentInterval.Load())
+
+	status := Status{
+		Transport:           "polling",
+		PollIntervalSeconds: int(interval / time.Second),
+		Auth:                c.AuthStatus(),
+		RateLimit:           c.appRateLimit.status(),
+	}
+	switch {
+	case c.eventSubTransport == "websocket":
+		status.Transport = "websocket"
+	case c.webhooksEnabled():
+		status.Transport = "webhook"
+	}
+	if c.userAccessToken != "" {
+		userStatus := c.userRateLimit.status()
+		status.UserRateLimit = &userStatus
+	}
+
+	return status
+}
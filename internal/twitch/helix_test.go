@@ -0,0 +1,60 @@
+package twitch
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/drmaq/streamnotification/internal/twitch/fakehelix"
+)
+
+func TestDoHelixRetriesAfterRateLimit(t *testing.T) {
+	sim := fakehelix.NewServer()
+	defer sim.Close()
+	client := newTestClient(t, sim)
+	sim.AddUser("alice", "")
+	if err := sim.GoLive("alice", "Stream", "Just Chatting", 10); err != nil {
+		t.Fatalf("GoLive: %v", err)
+	}
+
+	// The first attempt is answered with 429 and retried once the bucket refills
+	sim.ExhaustRateLimit(time.Second)
+	start := time.Now()
+
+	live, err := client.GetStreamStatus([]string{"alice"})
+	if err != nil {
+		t.Fatalf("GetStreamStatus: %v", err)
+	}
+	if _, ok := live["alice"]; !ok {
+		t.Fatal("alice missing from live streams after the retry")
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("retried after %v, before the bucket refilled", elapsed)
+	}
+
+	status := client.appRateLimit.status()
+	if status.Throttled != 1 {
+		t.Fatalf("recorded %d throttled responses, want 1", status.Throttled)
+	}
+	if status.Limit != 800 || status.Remaining != 799 {
+		t.Fatalf("bucket is %d/%d, want 799/800 from the rate limit headers", status.Remaining, status.Limit)
+	}
+}
+
+func TestRateLimitReserveKeepsReserve(t *testing.T) {
+	header := http.Header{}
+	header.Set("Ratelimit-Limit", "800")
+	header.Set("Ratelimit-Remaining", strconv.Itoa(rateLimitReserve+1))
+	header.Set("Ratelimit-Reset", strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10))
+
+	var bucket rateLimit
+	bucket.update(header)
+
+	if wait := bucket.reserve(); wait != 0 {
+		t.Fatalf("waited %v with points above the reserve", wait)
+	}
+	if wait := bucket.reserve(); wait <= 0 || wait > helixMaxWait {
+		t.Fatalf("waited %v with the bucket down to the reserve, want until the reset", wait)
+	}
+}
@@ -0,0 +1,194 @@
+package twitch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/drmaq/streamnotification/internal/db"
+	"github.com/drmaq/streamnotification/internal/errors"
+	"github.com/drmaq/streamnotification/internal/models"
+)
+
+// identityRefreshInterval is how often streamer logins and display names are refreshed
+const identityRefreshInterval = time.Hour
+
+// helixUser is a user returned by the Helix /users endpoint
+type helixUser struct {
+	ID          string `json:"id"`
+	Login       string `json:"login"`
+	DisplayName string `json:"display_name"`
+}
+
+// getUsers looks up Twitch users by the given /users query parameter ("login" or "id")
+func (c *Client) getUsers(param string, values []string) ([]helixUser, error) {
+	var users []helixUser
+
+	// Helix accepts at most 100 values per request
+	for start := 0; start < len(values); start += helixMaxBatchSize {
+		end := start + helixMaxBatchSize
+		if end > len(values) {
+			end = len(values)
+		}
+
+		query := url.Values{}
+		for _, value := range values[start:end] {
+			query.Add(param, value)
+		}
+
+		req, err := c.getAuthenticatedRequest("GET", "/users?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err // Error already wrapped
+		}
+
+		resp, err := c.doHelix(req)
+		if err != nil {
+			return nil, errors.NewAPIError("Failed to send request to Twitch API", err)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, errors.NewAPIError(
+				fmt.Sprintf("Twitch API request failed with status %d", resp.StatusCode),
+				fmt.Errorf("unexpected status code: %d", resp.StatusCode),
+			)
+		}
+
+		var result struct {
+			Data []helixUser `json:"data"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, errors.NewAPIError("Failed to parse Twitch API response", err)
+		}
+
+		users = append(users, result.Data...)
+	}
+
+	return users, nil
+}
+
+// broadcasterIDs returns the Twitch user IDs of the given streamers, resolving
+// the logins of streamers whose ID has not been backfilled yet
+func (c *Client) broadcasterIDs(streamers []models.Streamer) ([]string, error) {
+	var ids, usernames []string
+	for _, streamer := range streamers {
+		if streamer.TwitchUserID != "" {
+			ids = append(ids, streamer.TwitchUserID)
+		} else {
+			usernames = append(usernames, streamer.Username)
+		}
+	}
+
+	if len(usernames) > 0 {
+		users, err := c.getUsers("login", usernames)
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range users {
+			ids = append(ids, user.ID)
+		}
+	}
+
+	return ids, nil
+}
+
+// runIdentityRefresh refreshes streamer identities periodically until the context is cancelled
+func (c *Client) runIdentityRefresh(ctx context.Context, database *db.Database) {
+	ticker := time.NewTicker(identityRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := c.RefreshStreamerIdentities(database); err != nil {
+			c.logger.Error("Failed to refresh streamer identities: %v", err)
+		}
+	}
+}
+
+// RefreshStreamerIdentities backfills missing Twitch user IDs and picks up login
+// and display name changes, so renamed channels keep being tracked
+func (c *Client) RefreshStreamerIdentities(database *db.Database) error {
+	streamers, err := database.GetStreamers()
+	if err != nil {
+		return errors.NewInternalError("Failed to get streamers from database", err)
+	}
+
+	var ids, usernames []string
+	for _, streamer := range streamers {
+		if streamer.TwitchUserID != "" {
+			ids = append(ids, streamer.TwitchUserID)
+		} else {
+			usernames = append(usernames, streamer.Username)
+		}
+	}
+
+	// Look up known streamers by ID and the rest by login
+	byID := make(map[string]helixUser)
+	users, err := c.getUsers("id", ids)
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		byID[user.ID] = user
+	}
+
+	byLogin := make(map[string]helixUser)
+	users, err = c.getUsers("login", usernames)
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		byLogin[user.Login] = user
+	}
+
+	// Serialize with state transitions, which also write the streamer row
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	for i := range streamers {
+		streamer := &streamers[i]
+
+		var user helixUser
+		var ok bool
+		if streamer.TwitchUserID != "" {
+			user, ok = byID[streamer.TwitchUserID]
+		} else {
+			user, ok = byLogin[streamer.Username]
+		}
+		if !ok {
+			c.logger.Warn("Twitch user %s (%s) was not found, the account may be suspended or deleted",
+				streamer.Username, streamer.TwitchUserID)
+			continue
+		}
+
+		if user.ID == streamer.TwitchUserID && user.Login == streamer.Username && user.DisplayName == streamer.DisplayName {
+			continue
+		}
+
+		switch {
+		case streamer.TwitchUserID == "":
+			c.logger.Info("Backfilled Twitch user ID %s for %s", user.ID, streamer.Username)
+		case user.Login != streamer.Username:
+			c.logger.Info("Streamer %s was renamed to %s", streamer.Username, user.Login)
+		}
+
+		streamer.TwitchUserID = user.ID
+		streamer.Username = user.Login
+		streamer.DisplayName = user.DisplayName
+		if err := database.UpdateStreamerIdentity(streamer); err != nil {
+			c.logger.Error("Failed to update identity of streamer %d: %v", streamer.ID, err)
+		}
+	}
+
+	return nil
+}
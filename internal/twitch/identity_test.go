@@ -0,0 +1,70 @@
+package twitch
+
+import (
+	"testing"
+
+	"github.com/drmaq/streamnotification/internal/models"
+	"github.com/drmaq/streamnotification/internal/twitch/fakehelix"
+)
+
+func TestRenamedStreamerIsTrackedByID(t *testing.T) {
+	sim := fakehelix.NewServer()
+	defer sim.Close()
+	client := newTestClient(t, sim)
+
+	user := sim.AddUser("oldname", "OldName")
+	if err := sim.GoLive("oldname", "Stream", "Just Chatting", 10); err != nil {
+		t.Fatalf("GoLive: %v", err)
+	}
+	if err := sim.RenameUser("oldname", "newname", "NewName"); err != nil {
+		t.Fatalf("RenameUser: %v", err)
+	}
+
+	byID := models.Streamer{ID: 1, Username: "oldname", TwitchUserID: user.ID}
+	byLogin := models.Streamer{ID: 2, Username: "oldname"}
+
+	live, err := client.getLiveStreamers([]models.Streamer{byID, byLogin})
+	if err != nil {
+		t.Fatalf("getLiveStreamers: %v", err)
+	}
+
+	// Only the streamer with a backfilled ID survives the rename
+	event, ok := live[byID.ID]
+	if !ok {
+		t.Fatal("streamer tracked by ID was lost after the rename")
+	}
+	if event.Username != "newname" || event.DisplayName != "NewName" {
+		t.Fatalf("got %s (%s), want the new login newname (NewName)", event.Username, event.DisplayName)
+	}
+	if _, ok := live[byLogin.ID]; ok {
+		t.Fatal("streamer tracked by the old login was still found")
+	}
+}
+
+func TestGetUsersResolvesRenamedUser(t *testing.T) {
+	sim := fakehelix.NewServer()
+	defer sim.Close()
+	client := newTestClient(t, sim)
+
+	user := sim.AddUser("oldname", "")
+	if err := sim.RenameUser("oldname", "newname", "NewName"); err != nil {
+		t.Fatalf("RenameUser: %v", err)
+	}
+
+	users, err := client.getUsers("id", []string{user.ID})
+	if err != nil {
+		t.Fatalf("getUsers: %v", err)
+	}
+	if len(users) != 1 || users[0].Login != "newname" || users[0].DisplayName != "NewName" {
+		t.Fatalf("got %+v, want the renamed user", users)
+	}
+
+	// The old login no longer resolves, and the ID is kept for the new one
+	ids, err := client.broadcasterIDs([]models.Streamer{{Username: "oldname"}, {Username: "newname"}})
+	if err != nil {
+		t.Fatalf("broadcasterIDs: %v", err)
+	}
+	if len(ids) != 1 || ids[0] != user.ID {
+		t.Fatalf("got IDs %v, want [%s]", ids, user.ID)
+	}
+}
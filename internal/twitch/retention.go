@@ -0,0 +1,58 @@
+package twitch
+
+import (
+	"context"
+	"time"
+
+	"github.com/drmaq/streamnotification/internal/db"
+)
+
+const (
+	// sampleRetentionInterval is how often old stream samples are downsampled and deleted
+	sampleRetentionInterval = time.Hour
+
+	// sampleBucketSeconds is the resolution of downsampled stream samples
+	sampleBucketSeconds = 5 * 60
+)
+
+// runSampleRetention periodically applies the stream sample retention policy until the context is cancelled
+func (c *Client) runSampleRetention(ctx context.Context, database *db.Database) {
+	if c.sampleDownsampleDays == 0 && c.sampleRetentionDays == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(sampleRetentionInterval)
+	defer ticker.Stop()
+
+	for {
+		c.applySampleRetention(database)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// applySampleRetention averages samples older than the downsample age into buckets
+// and deletes samples older than the retention age
+func (c *Client) applySampleRetention(database *db.Database) {
+	if c.sampleDownsampleDays > 0 {
+		removed, err := database.DownsampleStreamSamples(c.sampleDownsampleDays, sampleBucketSeconds)
+		if err != nil {
+			c.logger.Error("Failed to downsample stream samples: %v", err)
+		} else if removed > 0 {
+			c.logger.Info("Downsampled stream samples older than %d days, removing %d", c.sampleDownsampleDays, removed)
+		}
+	}
+
+	if c.sampleRetentionDays > 0 {
+		deleted, err := database.DeleteStreamSamples(c.sampleRetentionDays)
+		if err != nil {
+			c.logger.Error("Failed to delete stream samples: %v", err)
+		} else if deleted > 0 {
+			c.logger.Info("Deleted %d stream samples older than %d days", deleted, c.sampleRetentionDays)
+		}
+	}
+}
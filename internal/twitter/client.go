@@ -1,40 +1,95 @@
 package twitter
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"mime/multipart"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/dghubble/oauth1"
+	"github.com/drmaq/streamnotification/internal/errors"
 	"github.com/drmaq/streamnotification/internal/logger"
 	"github.com/drmaq/streamnotification/internal/models"
-	"github.com/dghubble/go-twitter/twitter"
-	"github.com/dghubble/oauth1"
+	"github.com/drmaq/streamnotification/internal/notifier"
 )
 
-// Client represents a Twitter API client
+// defaultTemplates are the tweets posted when a notification setting has no template
+var defaultTemplates = map[string]string{
+	models.EventTypeLive: `{{.Streamer}} is now live on Twitch!
+
+{{.Title}}
+
+Playing: {{.Game}}
+
+{{.URL}}`,
+	models.EventTypeOffline: `Thanks for watching! {{.Streamer}}'s stream lasted {{.Duration}}.
+
+{{.Title}}
+
+Peak viewers: {{.PeakViewers}}
+
+{{.URL}}`,
+	models.EventTypeTitleChange: `{{.Streamer}} changed the stream title:
+
+{{.Title}}
+
+{{.URL}}`,
+	models.EventTypeCategoryChange: `{{.Streamer}} switched from {{.PreviousGame}} to {{.Game}}!
+
+{{.URL}}`,
+	models.EventTypeMilestone: `{{if .NewRecord}}New record! {{.Streamer}} has {{.Viewers}} viewers, beating the previous best of {{.PreviousPeak}}.{{else}}{{.Streamer}} just passed {{.Milestone}} viewers!{{end}}
+
+{{.Title}}
+
+{{.URL}}`,
+}
+
+const (
+	twitterAPIBaseURL = "https://api.x.com"
+
+	// maxTweetLength is the longest tweet, counted the way Twitter counts it
+	maxTweetLength = 280
+
+	// urlLength is the length Twitter counts for every link, after wrapping it in t.co
+	urlLength = 23
+
+	// maxThumbnailSize bounds the thumbnail download, Twitter accepts images up to 5MB
+	maxThumbnailSize = 5 << 20
+)
+
+// urlPattern matches the links Twitter shortens
+var urlPattern = regexp.MustCompile(`https?://\S+`)
+
+// Client represents a Twitter API v2 client posting with OAuth 1.0a user context
 type Client struct {
-	Logger           *logger.Logger
-	ConsumerKey      string
-	ConsumerSecret   string
-	AccessToken      string
+	Logger            *logger.Logger
+	ConsumerKey       string
+	ConsumerSecret    string
+	AccessToken       string
 	AccessTokenSecret string
-	client           *twitter.Client
-	Timeout          time.Duration
-	RetryCount       int
-	RetryDelay       time.Duration
+	Timeout           time.Duration
+	apiBaseURL        string
+	httpClient        *http.Client // Signs requests as the posting account
+	imageClient       *http.Client // Downloads thumbnails without credentials
 }
 
 // NewClient creates a new Twitter API client
 func NewClient(logger *logger.Logger, consumerKey, consumerSecret, accessToken, accessTokenSecret string) *Client {
 	c := &Client{
-		Logger:           logger,
-		ConsumerKey:      consumerKey,
-		ConsumerSecret:   consumerSecret,
-		AccessToken:      accessToken,
+		Logger:            logger,
+		ConsumerKey:       consumerKey,
+		ConsumerSecret:    consumerSecret,
+		AccessToken:       accessToken,
 		AccessTokenSecret: accessTokenSecret,
-		Timeout:          10 * time.Second, // Default timeout
-		RetryCount:       3,               // Default retry count
-		RetryDelay:       2 * time.Second, // Default retry delay
+		Timeout:           10 * time.Second, // Default timeout
+		apiBaseURL:        twitterAPIBaseURL,
 	}
 
 	// Initialize Twitter client if credentials are provided
@@ -45,68 +100,273 @@ func NewClient(logger *logger.Logger, consumerKey, consumerSecret, accessToken,
 	return c
 }
 
-// initClient initializes the Twitter API client
+// initClient initializes the OAuth 1.0a signed HTTP client
 func (c *Client) initClient() {
 	// Create OAuth1 config
 	config := oauth1.NewConfig(c.ConsumerKey, c.ConsumerSecret)
 	token := oauth1.NewToken(c.AccessToken, c.AccessTokenSecret)
 
 	// Create HTTP client with OAuth1 authentication and timeout
-	httpClient := &http.Client{
-		Timeout: c.Timeout,
+	c.httpClient = &http.Client{
+		Timeout:   c.Timeout,
 		Transport: config.Client(oauth1.NoContext, token).Transport,
 	}
+	c.imageClient = &http.Client{Timeout: c.Timeout}
+}
+
+// Capabilities describes the Twitter notification channel
+func (c *Client) Capabilities() notifier.Capabilities {
+	return notifier.Capabilities{
+		Type:             models.NotificationTypeTwitter,
+		Name:             "Twitter",
+		EventTypes:       models.EventTypes,
+		DestinationHint:  "Twitter account name used for posting",
+		DefaultTemplates: defaultTemplates,
+	}
+}
+
+// ValidateDestination checks that Twitter credentials are configured and an account is named
+func (c *Client) ValidateDestination(setting *models.NotificationSetting) error {
+	if c.httpClient == nil {
+		return errors.NewValidationError("Twitter credentials are not configured", nil)
+	}
+
+	if strings.TrimSpace(setting.Destination) == "" {
+		return errors.NewValidationError("Twitter destination must name the posting account", nil)
+	}
 
-	// Create Twitter client
-	c.client = twitter.NewClient(httpClient)
+	return nil
 }
 
-// SendNotification sends a notification tweet
-func (c *Client) SendNotification(event *models.StreamEvent) error {
+// Send posts a notification tweet, with the stream thumbnail attached while the stream is live
+func (c *Client) Send(ctx context.Context, setting *models.NotificationSetting, event *models.StreamEvent) (*notifier.Receipt, error) {
 	// Check if client is initialized
-	if c.client == nil {
-		return fmt.Errorf("Twitter client not initialized")
+	if c.httpClient == nil {
+		return nil, fmt.Errorf("Twitter client not initialized")
 	}
 
 	// Create tweet text
-	tweetText := fmt.Sprintf("%s is now live on Twitch!\n\n%s\n\nPlaying: %s\n\nhttps://twitch.tv/%s",
-		event.DisplayName,
-		event.StreamTitle,
-		event.GameName,
-		event.Username)
-
-	// Implement retry logic with exponential backoff
-	var tweet *twitter.Tweet
-	var resp *http.Response
-	var err error
-	
-	for attempt := 0; attempt <= c.RetryCount; attempt++ {
-		// If this is a retry, wait before attempting again
-		if attempt > 0 {
-			retryWait := c.RetryDelay * time.Duration(1 << uint(attempt-1)) // Exponential backoff
-			c.Logger.Info("Retrying Twitter API call in %v (attempt %d/%d)", retryWait, attempt, c.RetryCount)
-			time.Sleep(retryWait)
+	tweetText, err := fitTweet(setting, event)
+	if err != nil {
+		return nil, err
+	}
+
+	request := map[string]interface{}{"text": tweetText}
+
+	// A missing thumbnail should not hold back the tweet
+	if event.EventType != models.EventTypeOffline && event.ThumbnailURL != "" {
+		mediaID, err := c.uploadThumbnail(ctx, event)
+		if errors.IsRateLimitError(err) {
+			return nil, err
 		}
-		
-		// Post tweet
-		tweet, resp, err = c.client.Statuses.Update(tweetText, nil)
-		
-		// If successful or not a retriable error, break
-		if err == nil && resp.StatusCode == http.StatusOK {
-			break
+		if err != nil {
+			c.Logger.Warn("Posting tweet for %s without thumbnail: %v", event.DisplayName, err)
+		} else {
+			request["media"] = map[string]interface{}{"media_ids": []string{mediaID}}
 		}
-		
-		// If this was the last attempt, return the error
-		if attempt == c.RetryCount {
-			if err != nil {
-				return fmt.Errorf("failed to post tweet after %d attempts: %w", c.RetryCount+1, err)
-			}
-			return fmt.Errorf("Twitter API returned error status after %d attempts: %d", c.RetryCount+1, resp.StatusCode)
+	}
+
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal tweet: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.apiBaseURL+"/2/tweets", bytes.NewBuffer(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tweet request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var result struct {
+		Data struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	receipt, err := c.do(req, "post tweet", &result)
+	if err != nil {
+		return receipt, err
+	}
+	receipt.MessageID = result.Data.ID
+
+	c.Logger.Info("Sent Twitter notification for %s (Tweet ID: %s)", event.DisplayName, result.Data.ID)
+	return receipt, nil
+}
+
+// uploadThumbnail downloads the stream thumbnail and uploads it as tweet media, returning the media ID
+func (c *Client) uploadThumbnail(ctx context.Context, event *models.StreamEvent) (string, error) {
+	// Download the thumbnail, with a timestamp so a stale cached image is not served
+	imageURL := fmt.Sprintf("%s?t=%d", event.Thumbnail(1280, 720), time.Now().Unix())
+	req, err := http.NewRequestWithContext(ctx, "GET", imageURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create thumbnail request: %w", err)
+	}
+
+	resp, err := c.imageClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to download thumbnail: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("thumbnail download returned status %d", resp.StatusCode)
+	}
+	image, err := io.ReadAll(io.LimitReader(resp.Body, maxThumbnailSize+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read thumbnail: %w", err)
+	}
+	if len(image) > maxThumbnailSize {
+		return "", fmt.Errorf("thumbnail is larger than %d bytes", maxThumbnailSize)
+	}
+
+	// Upload it as multipart form data
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("media_category", "tweet_image")
+	part, err := form.CreateFormFile("media", "thumbnail.jpg")
+	if err != nil {
+		return "", fmt.Errorf("failed to create media upload: %w", err)
+	}
+	part.Write(image)
+	if err := form.Close(); err != nil {
+		return "", fmt.Errorf("failed to create media upload: %w", err)
+	}
+
+	req, err = http.NewRequestWithContext(ctx, "POST", c.apiBaseURL+"/2/media/upload", &body)
+	if err != nil {
+		return "", fmt.Errorf("failed to create media upload request: %w", err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	var result struct {
+		Data struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if _, err := c.do(req, "upload media", &result); err != nil {
+		return "", err
+	}
+	if result.Data.ID == "" {
+		return "", fmt.Errorf("Twitter media upload returned no media ID")
+	}
+
+	return result.Data.ID, nil
+}
+
+// do sends a signed API request, decodes a successful response into result and
+// translates Twitter errors into application errors
+func (c *Client) do(req *http.Request, action string, result interface{}) (*notifier.Receipt, error) {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to %s: %w", action, err)
+	}
+	defer resp.Body.Close()
+
+	receipt := &notifier.Receipt{StatusCode: resp.StatusCode}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		if err := json.Unmarshal(body, result); err != nil {
+			return receipt, fmt.Errorf("failed to decode Twitter response (status %d): %w", resp.StatusCode, err)
 		}
+		return receipt, nil
 	}
 
-	c.Logger.Info("Sent Twitter notification for %s (Tweet ID: %d)", event.DisplayName, tweet.ID)
-	return nil
+	reason := fmt.Errorf("Twitter failed to %s with status %d: %s", action, resp.StatusCode, strings.TrimSpace(string(body)))
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return receipt, errors.NewRateLimitError("Twitter is rate limited", rateLimitReset(resp.Header), reason)
+	case http.StatusUnauthorized:
+		return receipt, errors.NewUnauthorizedError("Twitter rejected the credentials", reason)
+	case http.StatusBadRequest, http.StatusForbidden:
+		// Includes duplicate tweets and apps without write access, neither succeeds on retry
+		return receipt, errors.NewValidationError("Twitter rejected the tweet", reason)
+	}
+	return receipt, reason
+}
+
+// rateLimitReset returns how long until a rate limit resets, preferring the daily
+// user limit when it is the one exhausted
+func rateLimitReset(header http.Header) time.Duration {
+	reset := header.Get("x-rate-limit-reset")
+	if header.Get("x-user-limit-24hour-remaining") == "0" && header.Get("x-user-limit-24hour-reset") != "" {
+		reset = header.Get("x-user-limit-24hour-reset")
+	}
+
+	seconds, err := strconv.ParseInt(strings.TrimSpace(reset), 10, 64)
+	if err != nil {
+		return errors.ParseRetryAfter(header.Get("Retry-After"))
+	}
+	if d := time.Until(time.Unix(seconds, 0)); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// fitTweet renders the tweet for an event, shortening the stream title so the tweet fits
+func fitTweet(setting *models.NotificationSetting, event *models.StreamEvent) (string, error) {
+	text, err := notifier.RenderMessage(setting, event, defaultTemplates)
+	if err != nil {
+		return "", err
+	}
+
+	over := tweetLength(text) - maxTweetLength
+	if over <= 0 {
+		return text, nil
+	}
+
+	// Drop enough of the end of the title to make room, including for the ellipsis
+	if title := []rune(event.StreamTitle); len(title) > 0 && strings.Contains(text, event.StreamTitle) {
+		keep := len(title)
+		for dropped := 0; keep > 0 && dropped < over+runeWeight('…'); keep-- {
+			dropped += runeWeight(title[keep-1])
+		}
+
+		shortened := *event
+		shortened.StreamTitle = ""
+		if keep > 0 {
+			shortened.StreamTitle = strings.TrimSpace(string(title[:keep])) + "…"
+		}
+		if text, err = notifier.RenderMessage(setting, &shortened, defaultTemplates); err != nil {
+			return "", err
+		}
+	}
+
+	// Templates without the title, or repeating it, are cut at the end
+	return truncate(text, maxTweetLength), nil
+}
+
+// tweetLength counts text the way Twitter does: every link as a t.co link and
+// characters outside the Latin and common punctuation ranges, such as CJK and emoji, twice
+func tweetLength(text string) int {
+	length := 0
+	for _, part := range urlPattern.Split(text, -1) {
+		for _, r := range part {
+			length += runeWeight(r)
+		}
+	}
+	return length + len(urlPattern.FindAllString(text, -1))*urlLength
+}
+
+// runeWeight returns the length Twitter counts for a character
+func runeWeight(r rune) int {
+	switch {
+	case r <= 0x10FF, r >= 0x2000 && r <= 0x200D, r >= 0x2010 && r <= 0x201F, r >= 0x2032 && r <= 0x2037:
+		return 1
+	}
+	return 2
+}
+
+// truncate shortens text to at most maxLength as Twitter counts it, ending it with an ellipsis
+func truncate(text string, maxLength int) string {
+	if tweetLength(text) <= maxLength {
+		return text
+	}
+
+	runes := []rune(text)
+	for len(runes) > 0 && tweetLength(string(runes))+runeWeight('…') > maxLength {
+		runes = runes[:len(runes)-1]
+	}
+	return strings.TrimSpace(string(runes)) + "…"
 }
 
 // UpdateCredentials updates the Twitter API credentials
@@ -128,9 +388,3 @@ func (c *Client) SetTimeout(timeout time.Duration) {
 		c.initClient()
 	}
 }
-
-// SetRetryOptions configures the retry behavior for failed API calls
-func (c *Client) SetRetryOptions(retryCount int, retryDelay time.Duration) {
-	c.RetryCount = retryCount
-	c.RetryDelay = retryDelay
-}
\ No newline at end of file
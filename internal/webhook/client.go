@@ -0,0 +1,222 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drmaq/streamnotification/internal/errors"
+	"github.com/drmaq/streamnotification/internal/logger"
+	"github.com/drmaq/streamnotification/internal/models"
+	"github.com/drmaq/streamnotification/internal/notifier"
+)
+
+// SchemaVersion is the version of the request body. It changes when a field is
+// removed or changes meaning; new fields may be added without a version change.
+const SchemaVersion = 1
+
+const (
+	// SignatureHeader carries "sha256=" and the hex HMAC-SHA256 of the timestamp, a '.' and the body
+	SignatureHeader = "X-StreamNotification-Signature"
+	// TimestampHeader carries the Unix time the request was signed at
+	TimestampHeader = "X-StreamNotification-Timestamp"
+	// IDHeader carries the delivery ID, which stays the same across retries
+	IDHeader = "X-StreamNotification-ID"
+	// EventHeader carries the event type
+	EventHeader = "X-StreamNotification-Event"
+)
+
+// defaultTemplates are the messages used when a notification setting has no template
+var defaultTemplates = map[string]string{
+	models.EventTypeLive:           "{{.Streamer}} is now live on Twitch!",
+	models.EventTypeOffline:        "{{.Streamer}} was live on Twitch",
+	models.EventTypeTitleChange:    "{{.Streamer}} changed the stream title",
+	models.EventTypeCategoryChange: "{{.Streamer}} switched to {{.Game}}",
+	models.EventTypeMilestone:      "{{.Streamer}} {{if .NewRecord}}set a new viewer record of {{.Viewers}}{{else}}reached {{.Milestone}} viewers{{end}}!",
+}
+
+// reservedHeaders are set by the notifier and cannot be overridden by a destination
+var reservedHeaders = map[string]bool{
+	"Content-Type":   true,
+	"Content-Length": true,
+	"Host":           true,
+	"User-Agent":     true,
+}
+
+// Client represents an outgoing webhook client
+type Client struct {
+	Logger     *logger.Logger
+	httpClient *http.Client
+}
+
+// NewClient creates a new outgoing webhook client
+func NewClient(logger *logger.Logger) *Client {
+	return &Client{
+		Logger:     logger,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Capabilities describes the webhook notification channel
+func (c *Client) Capabilities() notifier.Capabilities {
+	return notifier.Capabilities{
+		Type:             models.NotificationTypeWebhook,
+		Name:             "Webhook",
+		EventTypes:       models.EventTypes,
+		DestinationHint:  "URL that receives signed JSON event payloads",
+		DefaultTemplates: defaultTemplates,
+	}
+}
+
+// ValidateDestination checks the webhook URL, custom headers and signing secret
+func (c *Client) ValidateDestination(setting *models.NotificationSetting) error {
+	u, err := url.Parse(setting.Destination)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return errors.NewValidationError("Webhook destination must be an http or https URL", err)
+	}
+
+	for name := range setting.Headers {
+		canonical := textproto.CanonicalMIMEHeaderKey(name)
+		if !validHeaderName(name) {
+			return errors.NewValidationError(fmt.Sprintf("Invalid webhook header name: %q", name), nil)
+		}
+		if reservedHeaders[canonical] || strings.HasPrefix(canonical, "X-Streamnotification-") {
+			return errors.NewValidationError(fmt.Sprintf("Webhook header %s is set by StreamNotification", name), nil)
+		}
+	}
+
+	if setting.Secret == "" {
+		return errors.NewValidationError("Webhook destination needs a signing secret", nil)
+	}
+
+	return nil
+}
+
+// Payload is the JSON body of a webhook request
+type Payload struct {
+	SchemaVersion int                 `json:"schema_version"`
+	ID            string              `json:"id"`      // Same as the ID header
+	Type          string              `json:"type"`    // Event type, same as event.event_type
+	SentAt        time.Time           `json:"sent_at"` // Time of this attempt
+	Message       string              `json:"message"` // Rendered message template
+	Event         *models.StreamEvent `json:"event"`
+}
+
+// Send posts a signed event payload to the webhook URL of a notification setting
+func (c *Client) Send(ctx context.Context, setting *models.NotificationSetting, event *models.StreamEvent) (*notifier.Receipt, error) {
+	// Render the message
+	message, err := notifier.RenderMessage(setting, event, defaultTemplates)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := deliveryID(setting, event)
+	if err != nil {
+		return nil, err
+	}
+
+	// Marshal payload to JSON
+	body, err := json.Marshal(Payload{
+		SchemaVersion: SchemaVersion,
+		ID:            id,
+		Type:          event.EventType,
+		SentAt:        time.Now().UTC(),
+		Message:       message,
+		Event:         event,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal webhook payload: %w", err)
+	}
+
+	// Build the signed request
+	req, err := http.NewRequestWithContext(ctx, "POST", setting.Destination, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	for name, value := range setting.Headers {
+		req.Header.Set(name, value)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "StreamNotification-Webhook/1")
+	req.Header.Set(IDHeader, id)
+	req.Header.Set(EventHeader, event.EventType)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(setting.Secret, timestamp, body))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	receipt := &notifier.Receipt{StatusCode: resp.StatusCode, MessageID: id}
+
+	// Check response status
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		reason := fmt.Errorf("webhook returned error status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+
+		switch {
+		case resp.StatusCode == http.StatusTooManyRequests:
+			return receipt, errors.NewRateLimitError("Webhook is rate limited",
+				errors.ParseRetryAfter(resp.Header.Get("Retry-After")), reason)
+		case resp.StatusCode == http.StatusGone:
+			return receipt, errors.NewNotFoundError("Webhook no longer exists", reason)
+		case resp.StatusCode == http.StatusRequestTimeout:
+			return receipt, reason
+		case resp.StatusCode >= 400 && resp.StatusCode < 500:
+			// The receiver rejected the request, retrying the same payload will not help
+			return receipt, errors.NewValidationError("Webhook rejected the event", reason)
+		}
+		return receipt, reason
+	}
+
+	c.Logger.Info("Sent webhook notification for %s", event.DisplayName)
+	return receipt, nil
+}
+
+// Sign returns the signature header value of a request body sent at timestamp
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliveryID derives an ID from the destination and event, so every retry of a
+// delivery carries the same ID and receivers can drop duplicates
+func deliveryID(setting *models.NotificationSetting, event *models.StreamEvent) (string, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal webhook event: %w", err)
+	}
+
+	sum := sha256.Sum256(append([]byte(strconv.Itoa(setting.ID)+":"), payload...))
+	return hex.EncodeToString(sum[:16]), nil
+}
+
+// validHeaderName checks that a header name only contains HTTP token characters
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r > 127 || !(r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || strings.ContainsRune("!#$%&'*+-.^_`|~", r)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,37 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func TestSign(t *testing.T) {
+	secret := "topsecret"
+	timestamp := "1714588180"
+	body := []byte(`{"event_type":"live"}`)
+
+	// The signature covers the timestamp, a dot and the raw body
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + string(body)))
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	got := Sign(secret, timestamp, body)
+	if got != want {
+		t.Fatalf("Sign = %s, want %s", got, want)
+	}
+
+	// Computed with openssl dgst -sha256 -hmac
+	if got != "sha256=bb22fdb9087ed5303dea96fa896eeb291ceea58a8305996de9a0fc298d456443" {
+		t.Fatalf("Sign = %s, does not match the reference signature", got)
+	}
+
+	// Changing the timestamp or body changes the signature
+	if Sign(secret, "1714588181", body) == got {
+		t.Fatal("signature does not depend on the timestamp")
+	}
+	if Sign(secret, timestamp, []byte(`{"event_type":"offline"}`)) == got {
+		t.Fatal("signature does not depend on the body")
+	}
+}
@@ -0,0 +1,10 @@
+-- Remove event types from notification_settings
+ALTER TABLE notification_settings
+DROP COLUMN IF EXISTS event_types;
+
+-- Remove stream summary columns from streamers
+ALTER TABLE streamers
+DROP COLUMN IF EXISTS last_game_name,
+DROP COLUMN IF EXISTS last_stream_title,
+DROP COLUMN IF EXISTS peak_viewers,
+DROP COLUMN IF EXISTS last_stream_end;
@@ -0,0 +1,10 @@
+-- Track the state needed for stream-ended summaries
+ALTER TABLE streamers
+ADD COLUMN last_stream_end TIMESTAMP,
+ADD COLUMN peak_viewers INTEGER NOT NULL DEFAULT 0,
+ADD COLUMN last_stream_title TEXT NOT NULL DEFAULT '',
+ADD COLUMN last_game_name VARCHAR(255) NOT NULL DEFAULT '';
+
+-- Let each destination opt into the event types it receives
+ALTER TABLE notification_settings
+ADD COLUMN event_types TEXT[] NOT NULL DEFAULT '{live}';
@@ -0,0 +1,6 @@
+-- Remove indexes
+DROP INDEX IF EXISTS idx_notification_outbox_streamer_id;
+DROP INDEX IF EXISTS idx_notification_outbox_due;
+
+-- Drop notification_outbox table
+DROP TABLE IF EXISTS notification_outbox;
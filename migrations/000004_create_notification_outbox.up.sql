@@ -0,0 +1,19 @@
+-- Create notification_outbox table for durable delivery
+CREATE TABLE IF NOT EXISTS notification_outbox (
+    id SERIAL PRIMARY KEY,
+    notification_setting_id INTEGER NOT NULL REFERENCES notification_settings(id) ON DELETE CASCADE,
+    streamer_id INTEGER REFERENCES streamers(id) ON DELETE SET NULL,
+    event_type VARCHAR(50) NOT NULL,
+    payload JSONB NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    sent_at TIMESTAMP
+);
+
+-- Create indexes
+CREATE INDEX idx_notification_outbox_due ON notification_outbox(status, next_attempt_at);
+CREATE INDEX idx_notification_outbox_streamer_id ON notification_outbox(streamer_id);
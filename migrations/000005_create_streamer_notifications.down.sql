@@ -0,0 +1,9 @@
+-- Remove indexes
+DROP INDEX IF EXISTS idx_streamer_notifications_setting_id;
+
+-- Drop streamer_notifications table
+DROP TABLE IF EXISTS streamer_notifications;
+
+-- Remove all_streamers from notification_settings
+ALTER TABLE notification_settings
+DROP COLUMN IF EXISTS all_streamers;
@@ -0,0 +1,14 @@
+-- Existing destinations keep receiving notifications for every streamer
+ALTER TABLE notification_settings
+ADD COLUMN all_streamers BOOLEAN NOT NULL DEFAULT true;
+
+-- Create streamer_notifications table mapping streamers to destinations
+CREATE TABLE IF NOT EXISTS streamer_notifications (
+    streamer_id INTEGER NOT NULL REFERENCES streamers(id) ON DELETE CASCADE,
+    notification_setting_id INTEGER NOT NULL REFERENCES notification_settings(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (streamer_id, notification_setting_id)
+);
+
+-- Create indexes
+CREATE INDEX idx_streamer_notifications_setting_id ON streamer_notifications(notification_setting_id);
@@ -0,0 +1,3 @@
+-- Remove message templates
+ALTER TABLE notification_settings
+DROP COLUMN IF EXISTS templates;
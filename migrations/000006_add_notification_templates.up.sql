@@ -0,0 +1,3 @@
+-- Add per-destination message templates keyed by event type
+ALTER TABLE notification_settings
+ADD COLUMN templates JSONB NOT NULL DEFAULT '{}';
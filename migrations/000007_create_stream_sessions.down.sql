@@ -0,0 +1,8 @@
+-- Remove indexes
+DROP INDEX IF EXISTS idx_stream_session_changes_session_id;
+DROP INDEX IF EXISTS idx_stream_sessions_open;
+DROP INDEX IF EXISTS idx_stream_sessions_streamer_started;
+
+-- Drop tables
+DROP TABLE IF EXISTS stream_session_changes;
+DROP TABLE IF EXISTS stream_sessions;
@@ -0,0 +1,30 @@
+-- Create stream_sessions table recording every broadcast
+CREATE TABLE IF NOT EXISTS stream_sessions (
+    id SERIAL PRIMARY KEY,
+    streamer_id INTEGER NOT NULL REFERENCES streamers(id) ON DELETE CASCADE,
+    stream_id VARCHAR(255) NOT NULL DEFAULT '',
+    started_at TIMESTAMP NOT NULL,
+    ended_at TIMESTAMP,
+    title TEXT NOT NULL DEFAULT '',
+    game_name VARCHAR(255) NOT NULL DEFAULT '',
+    thumbnail_url TEXT NOT NULL DEFAULT '',
+    peak_viewers INTEGER NOT NULL DEFAULT 0,
+    viewer_total BIGINT NOT NULL DEFAULT 0,
+    viewer_samples INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create stream_session_changes table recording title and game changes during a broadcast
+CREATE TABLE IF NOT EXISTS stream_session_changes (
+    id SERIAL PRIMARY KEY,
+    session_id INTEGER NOT NULL REFERENCES stream_sessions(id) ON DELETE CASCADE,
+    title TEXT NOT NULL DEFAULT '',
+    game_name VARCHAR(255) NOT NULL DEFAULT '',
+    changed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create indexes
+CREATE INDEX idx_stream_sessions_streamer_started ON stream_sessions(streamer_id, started_at DESC);
+CREATE UNIQUE INDEX idx_stream_sessions_open ON stream_sessions(streamer_id) WHERE ended_at IS NULL;
+CREATE INDEX idx_stream_session_changes_session_id ON stream_session_changes(session_id);
@@ -0,0 +1,8 @@
+-- Remove indexes
+DROP INDEX IF EXISTS idx_deliveries_outbox_id;
+DROP INDEX IF EXISTS idx_deliveries_notification_setting_id;
+DROP INDEX IF EXISTS idx_deliveries_streamer_id;
+DROP INDEX IF EXISTS idx_deliveries_created_at;
+
+-- Drop deliveries table
+DROP TABLE IF EXISTS deliveries;
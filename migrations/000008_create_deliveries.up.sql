@@ -0,0 +1,25 @@
+-- Create deliveries table recording every notification delivery attempt.
+-- Streamer and destination details are copied so the history survives deletions.
+CREATE TABLE IF NOT EXISTS deliveries (
+    id SERIAL PRIMARY KEY,
+    outbox_id INTEGER NOT NULL,
+    streamer_id INTEGER NOT NULL,
+    streamer_name VARCHAR(255) NOT NULL DEFAULT '',
+    notification_setting_id INTEGER NOT NULL,
+    notification_type VARCHAR(50) NOT NULL DEFAULT '',
+    destination TEXT NOT NULL DEFAULT '',
+    event_type VARCHAR(50) NOT NULL,
+    attempt INTEGER NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    status_code INTEGER NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    latency_ms INTEGER NOT NULL DEFAULT 0,
+    message_id VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create indexes
+CREATE INDEX idx_deliveries_created_at ON deliveries(created_at DESC);
+CREATE INDEX idx_deliveries_streamer_id ON deliveries(streamer_id, created_at DESC);
+CREATE INDEX idx_deliveries_notification_setting_id ON deliveries(notification_setting_id, created_at DESC);
+CREATE INDEX idx_deliveries_outbox_id ON deliveries(outbox_id);
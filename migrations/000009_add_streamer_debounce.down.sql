@@ -0,0 +1,6 @@
+-- Remove flap suppression settings and state
+ALTER TABLE streamers
+DROP COLUMN IF EXISTS offline_since,
+DROP COLUMN IF EXISTS last_stream_id,
+DROP COLUMN IF EXISTS offline_grace_seconds,
+DROP COLUMN IF EXISTS notification_cooldown_seconds;
@@ -0,0 +1,6 @@
+-- Add per-streamer flap suppression settings and state
+ALTER TABLE streamers
+ADD COLUMN notification_cooldown_seconds INTEGER NOT NULL DEFAULT 900,
+ADD COLUMN offline_grace_seconds INTEGER NOT NULL DEFAULT 120,
+ADD COLUMN last_stream_id VARCHAR(255) NOT NULL DEFAULT '',
+ADD COLUMN offline_since TIMESTAMP;
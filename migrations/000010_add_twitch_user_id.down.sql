@@ -0,0 +1,6 @@
+-- Remove indexes
+DROP INDEX IF EXISTS idx_streamers_twitch_user_id;
+
+-- Remove twitch_user_id from streamers
+ALTER TABLE streamers
+DROP COLUMN IF EXISTS twitch_user_id;
@@ -0,0 +1,9 @@
+-- Track streamers by their immutable Twitch user ID. The IDs of existing rows
+-- are not known to the database, so there is no backfill here: the bot resolves
+-- them from their logins at runtime, when it starts and before it subscribes to
+-- or polls any stream. Until then they are looked up by login.
+ALTER TABLE streamers
+ADD COLUMN twitch_user_id VARCHAR(255) NOT NULL DEFAULT '';
+
+-- Create indexes
+CREATE UNIQUE INDEX idx_streamers_twitch_user_id ON streamers(twitch_user_id) WHERE twitch_user_id <> '';
@@ -0,0 +1,3 @@
+-- Remove notification filter rules
+ALTER TABLE notification_settings
+DROP COLUMN IF EXISTS filters;
@@ -0,0 +1,3 @@
+-- Add per-destination filter rules evaluated against each stream event
+ALTER TABLE notification_settings
+ADD COLUMN filters JSONB NOT NULL DEFAULT '{}';
@@ -0,0 +1,4 @@
+-- Remove viewer milestone settings
+ALTER TABLE streamers
+DROP COLUMN IF EXISTS milestone_thresholds,
+DROP COLUMN IF EXISTS record_viewers;
@@ -0,0 +1,9 @@
+-- Add per-streamer viewer milestone thresholds and the all-time peak viewer count
+ALTER TABLE streamers
+ADD COLUMN milestone_thresholds INTEGER[] NOT NULL DEFAULT '{100,500,1000}',
+ADD COLUMN record_viewers INTEGER NOT NULL DEFAULT 0;
+
+-- Start the all-time peak from the recorded stream history
+UPDATE streamers
+SET record_viewers = GREATEST(peak_viewers, COALESCE(
+    (SELECT MAX(peak_viewers) FROM stream_sessions WHERE stream_sessions.streamer_id = streamers.id), 0));
@@ -0,0 +1,6 @@
+-- Remove indexes
+DROP INDEX IF EXISTS idx_stream_samples_sampled_at;
+DROP INDEX IF EXISTS idx_stream_samples_session_sampled;
+
+-- Drop tables
+DROP TABLE IF EXISTS stream_samples;
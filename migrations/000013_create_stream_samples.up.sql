@@ -0,0 +1,14 @@
+-- Create stream_samples table recording the viewer count, title and game of every poll
+CREATE TABLE IF NOT EXISTS stream_samples (
+    id BIGSERIAL PRIMARY KEY,
+    session_id INTEGER NOT NULL REFERENCES stream_sessions(id) ON DELETE CASCADE,
+    sampled_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    viewer_count INTEGER NOT NULL DEFAULT 0,
+    title TEXT NOT NULL DEFAULT '',
+    game_name VARCHAR(255) NOT NULL DEFAULT '',
+    bucket_seconds INTEGER NOT NULL DEFAULT 0
+);
+
+-- Create indexes
+CREATE INDEX idx_stream_samples_session_sampled ON stream_samples(session_id, sampled_at);
+CREATE INDEX idx_stream_samples_sampled_at ON stream_samples(sampled_at);
@@ -0,0 +1,4 @@
+-- Remove outgoing webhook headers and secrets
+ALTER TABLE notification_settings
+DROP COLUMN IF EXISTS headers,
+DROP COLUMN IF EXISTS secret;
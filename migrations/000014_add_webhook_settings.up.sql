@@ -0,0 +1,4 @@
+-- Add the custom request headers and signing secret of outgoing webhook destinations
+ALTER TABLE notification_settings
+ADD COLUMN headers JSONB NOT NULL DEFAULT '{}',
+ADD COLUMN secret TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,6 @@
+-- Drop tables
+DROP TABLE IF EXISTS stream_messages;
+
+-- Remove go-live mentions
+ALTER TABLE notification_settings
+DROP COLUMN IF EXISTS mention;
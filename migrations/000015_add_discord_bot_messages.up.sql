@@ -0,0 +1,14 @@
+-- Add the role or @everyone mention of go-live posts
+ALTER TABLE notification_settings
+ADD COLUMN mention VARCHAR(32) NOT NULL DEFAULT '';
+
+-- Create stream_messages table recording the go-live message each destination received for a stream,
+-- so it can be edited when the stream ends
+CREATE TABLE IF NOT EXISTS stream_messages (
+    notification_setting_id INTEGER NOT NULL REFERENCES notification_settings(id) ON DELETE CASCADE,
+    stream_id VARCHAR(255) NOT NULL,
+    streamer_id INTEGER REFERENCES streamers(id) ON DELETE SET NULL,
+    message_id VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (notification_setting_id, stream_id)
+);
@@ -0,0 +1,3 @@
+-- Remove the all-time peak at stream start
+ALTER TABLE streamers
+DROP COLUMN IF EXISTS record_viewers_at_start;
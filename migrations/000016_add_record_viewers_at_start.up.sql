@@ -0,0 +1,5 @@
+-- Keep the all-time peak as it was when the current stream started, so a stream
+-- beating it is announced once. Streams live during the upgrade start from 0,
+-- which announces no record until their next go-live.
+ALTER TABLE streamers
+ADD COLUMN record_viewers_at_start INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+-- Remove the offline summary marker
+ALTER TABLE streamers
+DROP COLUMN IF EXISTS offline_notified;
@@ -0,0 +1,4 @@
+-- Mark streams whose offline summary was already queued, as for a restart that
+-- continues the previous stream within the notification cooldown
+ALTER TABLE streamers
+ADD COLUMN offline_notified BOOLEAN NOT NULL DEFAULT FALSE;